@@ -1,10 +1,16 @@
 package ast
 
-import "fmt"
+import (
+	"math/big"
+
+	"github.com/flychario/flylang/token"
+)
 
 type Element interface {
 	ElementType() ElementType
-	Eval(*Context) Element
+	Eval(*Context) (Element, error)
+	Pos() token.Position // position of the first character of the element
+	End() token.Position // position immediately after the element
 }
 
 type List interface {
@@ -13,9 +19,19 @@ type List interface {
 }
 
 type Callable interface {
-	Call(*Context, []Element) Element
+	Call(*Context, []Element) (Element, error)
+}
+
+// Span is the source range of an element produced by the parser. It is
+// embedded in every element and zero for elements created at runtime.
+type Span struct {
+	StartPos token.Position
+	EndPos   token.Position
 }
 
+func (s Span) Pos() token.Position { return s.StartPos }
+func (s Span) End() token.Position { return s.EndPos }
+
 type ElementType int
 
 const (
@@ -24,6 +40,8 @@ const (
 	ElementTypeLiteral
 	ElementTypeList
 	ElementTypeProgram
+	ElementTypeMap
+	ElementTypeVector
 
 	keywords
 	ElementTypeQuote
@@ -32,6 +50,15 @@ const (
 	ElementTypeLambda
 	ElementTypeProg
 	ElementTypeCond
+	ElementTypeIf
+	ElementTypeLet
+	ElementTypeLogical
+	ElementTypeWhen
+	ElementTypeDefMacro
+	ElementTypeDefineSyntax
+	ElementTypeQuasiquote
+	ElementTypeUnquote
+	ElementTypeUnquoteSplicing
 	ElementTypeWhile
 	ElementTypeReturn
 	ElementTypeBreak
@@ -47,10 +74,22 @@ const (
 	LiteralTypeBoolean
 	LiteralTypeNull
 	LiteralTypeList
+	LiteralTypeString
+	LiteralTypeRational
 )
 
 type Atom struct {
-	Name string
+	Span
+	Name    string
+	Address // set by Resolve for variables bound by lambdas and lets
+}
+
+// Address is the lexical address of a variable: slot Index of the context
+// Depth levels above the one an atom is evaluated in. Atoms whose address is
+// not Resolved are looked up by name.
+type Address struct {
+	Depth, Index int
+	Resolved     bool
 }
 
 type Literal interface {
@@ -59,112 +98,307 @@ type Literal interface {
 }
 
 type LiteralInteger struct {
+	Span
 	Value int64
 }
 
+// LiteralBigInteger is an integer that does not fit in an int64. Integer
+// arithmetic gives big integers only for results out of the range of
+// LiteralInteger.
+type LiteralBigInteger struct {
+	Span
+	Value *big.Int
+}
+
+// LiteralRational is an exact fraction whose denominator is not 1. Division
+// of integers that do not divide evenly gives rationals.
+type LiteralRational struct {
+	Span
+	Value *big.Rat
+}
+
 type LiteralReal struct {
+	Span
 	Value float64
 }
 
 type LiteralBoolean struct {
+	Span
 	Value bool
 }
 
 type LiteralNull struct {
+	Span
 	Value interface{}
 }
 
+type LiteralString struct {
+	Span
+	Value string
+}
+
 type LiteralList struct {
+	Span
 	Value []Literal
 }
 
 type ListElement struct {
+	Span
 	Elements []Element
 }
 
-type Program struct {
+// MapElement is a map literal, {key value ...}. Its keys and values are
+// evaluated in order and give a Map.
+type MapElement struct {
+	Span
 	Elements []Element
 }
 
-func (a Atom) ElementType() ElementType           { return ElementTypeAtom }
-func (l LiteralInteger) ElementType() ElementType { return ElementTypeLiteral }
-func (l LiteralReal) ElementType() ElementType    { return ElementTypeLiteral }
-func (l LiteralBoolean) ElementType() ElementType { return ElementTypeLiteral }
-func (l LiteralNull) ElementType() ElementType    { return ElementTypeLiteral }
-func (l LiteralList) ElementType() ElementType    { return ElementTypeList }
-func (l ListElement) ElementType() ElementType    { return ElementTypeList }
-func (l ListElement) GetElements() []Element      { return l.Elements }
-func (p Program) ElementType() ElementType        { return ElementTypeProgram }
+// Map is a map from hashable values, literals other than lists and atoms, to
+// values. Maps are persistent: put, del and merge give new maps and leave
+// their arguments unchanged. Keys are kept in the order they were added.
+type Map struct {
+	Span
+	root *mapNode // nil for the empty map
+	size int
+	next int // order of the next key added
+}
 
-func (l LiteralInteger) Type() LiteralType { return LiteralTypeInteger }
-func (l LiteralReal) Type() LiteralType    { return LiteralTypeReal }
-func (l LiteralBoolean) Type() LiteralType { return LiteralTypeBoolean }
-func (l LiteralNull) Type() LiteralType    { return LiteralTypeNull }
-func (l LiteralList) Type() LiteralType    { return LiteralTypeList }
+// VectorElement is a vector literal, #(element ...). Its elements are
+// evaluated in order and give a Vector.
+type VectorElement struct {
+	Span
+	Elements []Element
+}
+
+// Vector is a sequence of values indexed from 0. Unlike lists, a vector is
+// changed in place by vector-set, and its copies share the change. Constant
+// vectors, quoted in the program as '#(1 2), can't be changed.
+type Vector struct {
+	Span
+	Elements []Element
+	Constant bool
+}
+
+type Program struct {
+	Span
+	Elements []Element
+}
+
+func (a Atom) ElementType() ElementType              { return ElementTypeAtom }
+func (l LiteralInteger) ElementType() ElementType    { return ElementTypeLiteral }
+func (l LiteralBigInteger) ElementType() ElementType { return ElementTypeLiteral }
+func (l LiteralRational) ElementType() ElementType   { return ElementTypeLiteral }
+func (l LiteralReal) ElementType() ElementType       { return ElementTypeLiteral }
+func (l LiteralBoolean) ElementType() ElementType    { return ElementTypeLiteral }
+func (l LiteralNull) ElementType() ElementType       { return ElementTypeLiteral }
+func (l LiteralString) ElementType() ElementType     { return ElementTypeLiteral }
+func (l LiteralList) ElementType() ElementType       { return ElementTypeList }
+func (l ListElement) ElementType() ElementType       { return ElementTypeList }
+func (l ListElement) GetElements() []Element         { return l.Elements }
+func (m MapElement) ElementType() ElementType        { return ElementTypeMap }
+func (m Map) ElementType() ElementType               { return ElementTypeMap }
+func (v VectorElement) ElementType() ElementType     { return ElementTypeVector }
+func (v Vector) ElementType() ElementType            { return ElementTypeVector }
+func (p Program) ElementType() ElementType           { return ElementTypeProgram }
+
+func (l LiteralInteger) Type() LiteralType    { return LiteralTypeInteger }
+func (l LiteralBigInteger) Type() LiteralType { return LiteralTypeInteger }
+func (l LiteralRational) Type() LiteralType   { return LiteralTypeRational }
+func (l LiteralReal) Type() LiteralType       { return LiteralTypeReal }
+func (l LiteralBoolean) Type() LiteralType    { return LiteralTypeBoolean }
+func (l LiteralNull) Type() LiteralType       { return LiteralTypeNull }
+func (l LiteralString) Type() LiteralType     { return LiteralTypeString }
+func (l LiteralList) Type() LiteralType       { return LiteralTypeList }
 
 type Quote struct {
+	Span
+	Element Element
+}
+
+// Quasiquote evaluates to its template, a datum, with the elements marked by
+// Unquote replaced by their values and the ones marked by UnquoteSplicing
+// by the elements of their values.
+type Quasiquote struct {
+	Span
+	Element Element
+}
+
+type Unquote struct {
+	Span
+	Element Element
+}
+
+type UnquoteSplicing struct {
+	Span
 	Element Element
 }
 
 type Setq struct {
+	Span
 	Atom    Atom
 	Element Element
 }
 
 type Func struct {
+	Span
 	Atom    Atom
 	List    List
 	SubProg Program
+	Names   []string // slots of the calls, nil if not resolved
 }
 
 type Lambda struct {
+	Span
 	List    List
 	SubProg Program
+	Names   []string // slots of the calls, nil if not resolved
+	Context *Context // defining context, nil until the lambda is evaluated
 }
 
 type Prog struct {
+	Span
 	List    List
 	SubProg Program
 }
 
+// Cond evaluates the body of the first clause whose test is true.
 type Cond struct {
-	List     List
-	Element1 Element
-	Element2 Element
+	Span
+	Clauses []Clause
+}
+
+// Clause is a clause of a cond. The test of an else clause is nil.
+type Clause struct {
+	Span
+	Test Element
+	Body Program
+}
+
+// If evaluates Then when Test is true and Else, which may be nil, otherwise.
+type If struct {
+	Span
+	Test Element
+	Then Element
+	Else Element
+}
+
+// Let evaluates Body in a new context holding Bindings. Kind is LET, which
+// evaluates the values in the enclosing context, LET_STAR, which evaluates
+// each value after binding the ones before it, or LETREC, which evaluates the
+// values with all names bound, so bound lambdas can call each other.
+type Let struct {
+	Span
+	Kind     token.Token
+	Bindings []Binding
+	Body     Program
+	Names    []string // slots of the body's context, nil if not resolved
+}
+
+type Binding struct {
+	Span
+	Name  Atom
+	Value Element
+}
+
+// Logical is an and or or, as given by Kind. Its operands are evaluated from
+// left to right until one decides the result, which is the value of that
+// operand. Without operands and is true and or is false.
+type Logical struct {
+	Span
+	Kind     token.Token
+	Elements []Element
+}
+
+// When evaluates Body if Test is true, or for Kind UNLESS, if it is false.
+type When struct {
+	Span
+	Kind token.Token
+	Test Element
+	Body Program
+}
+
+// DefMacro defines a macro whose body computes the expansion of a call from
+// the unevaluated arguments.
+type DefMacro struct {
+	Span
+	Atom    Atom
+	List    List
+	SubProg Program
+}
+
+// DefineSyntax defines a macro by syntax-rules. The rules are kept as data,
+// with keywords read as atoms.
+type DefineSyntax struct {
+	Span
+	Atom     Atom
+	Literals []Atom
+	Rules    []SyntaxRule
+}
+
+// SyntaxRule rewrites calls matching Pattern into Template.
+type SyntaxRule struct {
+	Span
+	Pattern  Element
+	Template Element
 }
 
 type While struct {
+	Span
 	Element1 Element
 	Element2 Program
 }
 
 type Return struct {
+	Span
 	Element Element
 }
 
 type Break struct {
+	Span
 }
 
-func (q Quote) ElementType() ElementType  { return ElementTypeQuote }
-func (s Setq) ElementType() ElementType   { return ElementTypeSetq }
-func (f Func) ElementType() ElementType   { return ElementTypeFunc }
-func (l Lambda) ElementType() ElementType { return ElementTypeLambda }
-func (p Prog) ElementType() ElementType   { return ElementTypeProg }
-func (c Cond) ElementType() ElementType   { return ElementTypeCond }
-func (w While) ElementType() ElementType  { return ElementTypeWhile }
-func (r Return) ElementType() ElementType { return ElementTypeReturn }
-func (b Break) ElementType() ElementType  { return ElementTypeBreak }
-
-func (q Quote) GetElements() []Element  { return []Element{q.Element} }
-func (s Setq) GetElements() []Element   { return []Element{s.Element} }
-func (f Func) GetElements() []Element   { return []Element{f.List} }
-func (l Lambda) GetElements() []Element { return []Element{l.List} }
-func (p Prog) GetElements() []Element   { return []Element{p.List} }
-func (c Cond) GetElements() []Element   { return []Element{c.List, c.Element1, c.Element2} }
-func (w While) GetElements() []Element  { return []Element{w.Element1, w.Element2} }
-func (r Return) GetElements() []Element { return []Element{r.Element} }
-func (b Break) GetElements() []Element  { return []Element{} }
-
-func CreateEvaluateError(msg string) {
-	panic(fmt.Sprintf("Error occured during execution: %s", msg))
+func (q Quote) ElementType() ElementType           { return ElementTypeQuote }
+func (q Quasiquote) ElementType() ElementType      { return ElementTypeQuasiquote }
+func (u Unquote) ElementType() ElementType         { return ElementTypeUnquote }
+func (u UnquoteSplicing) ElementType() ElementType { return ElementTypeUnquoteSplicing }
+func (s Setq) ElementType() ElementType            { return ElementTypeSetq }
+func (f Func) ElementType() ElementType            { return ElementTypeFunc }
+func (l Lambda) ElementType() ElementType          { return ElementTypeLambda }
+func (p Prog) ElementType() ElementType            { return ElementTypeProg }
+func (c Cond) ElementType() ElementType            { return ElementTypeCond }
+func (i If) ElementType() ElementType              { return ElementTypeIf }
+func (l Let) ElementType() ElementType             { return ElementTypeLet }
+func (l Logical) ElementType() ElementType         { return ElementTypeLogical }
+func (w When) ElementType() ElementType            { return ElementTypeWhen }
+func (d DefMacro) ElementType() ElementType        { return ElementTypeDefMacro }
+func (d DefineSyntax) ElementType() ElementType    { return ElementTypeDefineSyntax }
+func (w While) ElementType() ElementType           { return ElementTypeWhile }
+func (r Return) ElementType() ElementType          { return ElementTypeReturn }
+func (b Break) ElementType() ElementType           { return ElementTypeBreak }
+
+func (q Quote) GetElements() []Element           { return []Element{q.Element} }
+func (q Quasiquote) GetElements() []Element      { return []Element{q.Element} }
+func (u Unquote) GetElements() []Element         { return []Element{u.Element} }
+func (u UnquoteSplicing) GetElements() []Element { return []Element{u.Element} }
+func (s Setq) GetElements() []Element            { return []Element{s.Element} }
+func (f Func) GetElements() []Element            { return []Element{f.List} }
+func (l Lambda) GetElements() []Element          { return []Element{l.List} }
+func (p Prog) GetElements() []Element            { return []Element{p.List} }
+func (i If) GetElements() []Element              { return []Element{i.Test, i.Then, i.Else} }
+func (l Let) GetElements() []Element             { return []Element{l.Body} }
+func (l Logical) GetElements() []Element         { return l.Elements }
+func (w When) GetElements() []Element            { return []Element{w.Test, w.Body} }
+func (d DefMacro) GetElements() []Element        { return []Element{d.List} }
+func (d DefineSyntax) GetElements() []Element    { return []Element{} }
+func (w While) GetElements() []Element           { return []Element{w.Element1, w.Element2} }
+func (r Return) GetElements() []Element          { return []Element{r.Element} }
+func (b Break) GetElements() []Element           { return []Element{} }
+
+func (c Cond) GetElements() []Element {
+	var elements []Element
+	for _, clause := range c.Clauses {
+		elements = append(elements, clause.Test, clause.Body)
+	}
+	return elements
 }
@@ -1,26 +1,30 @@
 package ast
 
-import (
-	"fmt"
-)
-
 type Builtin struct {
-	Name string
-	Args []Element
-	Code func(*Context, []Element) Element
+	Span
+	Name     string
+	Args     []Element
+	Variadic bool // the last of Args stands for any number of arguments
+	Code     func(*Context, []Element) (Element, error)
+
+	// Binary, if set, gives the result of a call with two literal
+	// arguments, the same as Code, without evaluating them again.
+	Binary func(a, b Literal) (Element, error)
 }
 
 func (b Builtin) ElementType() ElementType {
 	return ElementTypeLambda
 }
 
-func (b Builtin) Eval(c *Context) Element {
-	return b
+func (b Builtin) Eval(c *Context) (Element, error) {
+	return b, nil
 }
 
-func (b Builtin) Call(c *Context, args []Element) Element {
-	if len(args) != len(b.Args) {
-		CreateEvaluateError(fmt.Sprintf("Wrong number of arguments to %s: %d != %d", b.Name, len(args), len(b.Args)))
+func (b Builtin) Call(c *Context, args []Element) (Element, error) {
+	if b.Variadic && len(args) < len(b.Args)-1 {
+		return nil, NewRuntimeError(ErrorArityMismatch, "Not enough arguments to %s: %d < %d", b.Name, len(args), len(b.Args)-1)
+	} else if !b.Variadic && len(args) != len(b.Args) {
+		return nil, NewRuntimeError(ErrorArityMismatch, "Wrong number of arguments to %s: %d != %d", b.Name, len(args), len(b.Args))
 	}
 	return b.Code(c, args)
 }
@@ -35,262 +39,125 @@ func (b Builtin) String() string {
 
 var Builtins = []Builtin{
 	{
-		Name: "plus",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't add %v and %v", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralInteger{a.(LiteralInteger).Value + b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralReal{a.(LiteralReal).Value + b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralReal{float64(a.(LiteralInteger).Value) + b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralReal{a.(LiteralReal).Value + float64(b.(LiteralInteger).Value)}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't add %v and %v", a, b))
-			return nil
+		Name:     "plus",
+		Args:     []Element{Atom{Name: "numbers"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return arithmetic(c, args, "Can't add", LiteralInteger{Value: 0}, add)
 		},
+		Binary: add,
 	},
 	{
-		Name: "minus",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't add %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralInteger{a.(LiteralInteger).Value - b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralReal{a.(LiteralReal).Value - b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralReal{float64(a.(LiteralInteger).Value) - b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralReal{a.(LiteralReal).Value - float64(b.(LiteralInteger).Value)}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't subtract %s and %s", a, b))
-			return nil
+		Name:     "minus",
+		Args:     []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return arithmetic(c, args, "Can't subtract", LiteralInteger{Value: 0}, subtract)
 		},
+		Binary: subtract,
 	},
 	{
-		Name: "times",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't add %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralInteger{a.(LiteralInteger).Value * b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralReal{a.(LiteralReal).Value * b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralReal{float64(a.(LiteralInteger).Value) * b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralReal{a.(LiteralReal).Value * float64(b.(LiteralInteger).Value)}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't multiply %s and %s", a, b))
-			return nil
+		Name:     "times",
+		Args:     []Element{Atom{Name: "numbers"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return arithmetic(c, args, "Can't multiply", LiteralInteger{Value: 1}, multiply)
 		},
+		Binary: multiply,
 	},
 	{
-		Name: "divide",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't add %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralInteger{a.(LiteralInteger).Value / b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralReal{a.(LiteralReal).Value / b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralReal{float64(a.(LiteralInteger).Value) / b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralReal{a.(LiteralReal).Value / float64(b.(LiteralInteger).Value)}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't divide %s and %s", a, b))
-			return nil
+		Name:     "divide",
+		Args:     []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return arithmetic(c, args, "Can't divide", LiteralInteger{Value: 1}, divide)
 		},
+		Binary: divide,
 	},
 	{
-		Name: "equal",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralInteger).Value == b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{a.(LiteralReal).Value == b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{float64(a.(LiteralInteger).Value) == b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralReal).Value == float64(b.(LiteralInteger).Value)}
-			} else if a.Type() == LiteralTypeBoolean && b.Type() == LiteralTypeBoolean {
-				return LiteralBoolean{a.(LiteralBoolean).Value == b.(LiteralBoolean).Value}
-			} else if a.Type() == LiteralTypeNull && b.Type() == LiteralTypeNull {
-				return LiteralBoolean{true}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", a, b))
-			return nil
+		Name:     "equal",
+		Args:     []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return compare(c, args, "Can't compare", equal)
 		},
+		Binary: equal,
 	},
 	{
-		Name: "nonequal",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralInteger).Value != b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{a.(LiteralReal).Value != b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{float64(a.(LiteralInteger).Value) != b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralReal).Value != float64(b.(LiteralInteger).Value)}
-			} else if a.Type() == LiteralTypeBoolean && b.Type() == LiteralTypeBoolean {
-				return LiteralBoolean{a.(LiteralBoolean).Value != b.(LiteralBoolean).Value}
-			} else if a.Type() == LiteralTypeNull && b.Type() == LiteralTypeNull {
-				return LiteralBoolean{false}
-			} else if a.Type() == LiteralTypeNull && b.Type() == LiteralTypeNull {
-				return LiteralBoolean{false}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", a, b))
-			return nil
+		Name:     "nonequal",
+		Args:     []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return compare(c, args, "Can't compare", nonequal)
 		},
+		Binary: nonequal,
 	},
 	{
-		Name: "less",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralInteger).Value < b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{a.(LiteralReal).Value < b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{float64(a.(LiteralInteger).Value) < b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralReal).Value < float64(b.(LiteralInteger).Value)}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", a, b))
-			return nil
+		Name:     "less",
+		Args:     []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return compare(c, args, "Can't compare", less)
 		},
+		Binary: less,
 	},
 	{
-		Name: "lesseq",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't add %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralInteger).Value <= b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{a.(LiteralReal).Value <= b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{float64(a.(LiteralInteger).Value) <= b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralReal).Value <= float64(b.(LiteralInteger).Value)}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", a, b))
-			return nil
+		Name:     "lesseq",
+		Args:     []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return compare(c, args, "Can't compare", lessEq)
 		},
+		Binary: lessEq,
 	},
 	{
-		Name: "greater",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralInteger).Value > b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{a.(LiteralReal).Value > b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{float64(a.(LiteralInteger).Value) > b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralReal).Value > float64(b.(LiteralInteger).Value)}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", a, b))
-			return nil
+		Name:     "greater",
+		Args:     []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return compare(c, args, "Can't compare", greater)
 		},
+		Binary: greater,
 	},
 	{
-		Name: "greatereq",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-			if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralInteger).Value >= b.(LiteralInteger).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{a.(LiteralReal).Value >= b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeInteger && b.Type() == LiteralTypeReal {
-				return LiteralBoolean{float64(a.(LiteralInteger).Value) >= b.(LiteralReal).Value}
-			} else if a.Type() == LiteralTypeReal && b.Type() == LiteralTypeInteger {
-				return LiteralBoolean{a.(LiteralReal).Value >= float64(b.(LiteralInteger).Value)}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't compare %s and %s", a, b))
-			return nil
+		Name:     "greatereq",
+		Args:     []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return compare(c, args, "Can't compare", greaterEq)
+		},
+		Binary: greaterEq,
+	},
+	{
+		Name: "exact->inexact",
+		Args: []Element{Atom{Name: "n"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			n, err := evalNumber(c, args[0], "exact->inexact")
+			if err != nil {
+				return nil, err
+			}
+			return LiteralReal{Value: toFloat(n)}, nil
+		},
+	},
+	{
+		Name: "inexact->exact",
+		Args: []Element{Atom{Name: "n"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			n, err := evalNumber(c, args[0], "inexact->exact")
+			if err != nil {
+				return nil, err
+			}
+			return exact(n)
 		},
 	},
 	{
 		Name: "isint",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			ae, err := args[0].Eval(c)
+			if err != nil {
+				return nil, err
+			}
 
 			var isElementType = ae.ElementType() == ElementTypeLiteral
 			var isValidLiteralType = false
@@ -299,14 +166,17 @@ var Builtins = []Builtin{
 				isValidLiteralType = ae.(Literal).Type() == LiteralTypeInteger
 			}
 
-			return LiteralBoolean{isValidLiteralType}
+			return LiteralBoolean{Value: isValidLiteralType}, nil
 		},
 	},
 	{
 		Name: "isreal",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			ae, err := args[0].Eval(c)
+			if err != nil {
+				return nil, err
+			}
 
 			var isElementType = ae.ElementType() == ElementTypeLiteral
 			var isValidLiteralType = false
@@ -315,14 +185,17 @@ var Builtins = []Builtin{
 				isValidLiteralType = ae.(Literal).Type() == LiteralTypeReal
 			}
 
-			return LiteralBoolean{isValidLiteralType}
+			return LiteralBoolean{Value: isValidLiteralType}, nil
 		},
 	},
 	{
 		Name: "isbool",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			ae, err := args[0].Eval(c)
+			if err != nil {
+				return nil, err
+			}
 
 			var isElementType = ae.ElementType() == ElementTypeLiteral
 			var isValidLiteralType = false
@@ -331,14 +204,17 @@ var Builtins = []Builtin{
 				isValidLiteralType = ae.(Literal).Type() == LiteralTypeBoolean
 			}
 
-			return LiteralBoolean{isValidLiteralType}
+			return LiteralBoolean{Value: isValidLiteralType}, nil
 		},
 	},
 	{
 		Name: "isnull",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			ae, err := args[0].Eval(c)
+			if err != nil {
+				return nil, err
+			}
 
 			var isElementType = ae.ElementType() == ElementTypeLiteral
 			var isValidLiteralType = false
@@ -347,167 +223,119 @@ var Builtins = []Builtin{
 				isValidLiteralType = ae.(Literal).Type() == LiteralTypeNull
 			}
 
-			return LiteralBoolean{isValidLiteralType}
+			return LiteralBoolean{Value: isValidLiteralType}, nil
 		},
 	},
 	{
 		Name: "isatom",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
 			var isElementType = args[0].ElementType() == ElementTypeAtom
 
-			return LiteralBoolean{isElementType}
+			return LiteralBoolean{Value: isElementType}, nil
 		},
 	},
 	{
 		Name: "islist",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
 			var isElementType = args[0].ElementType() == ElementTypeList
 
-			return LiteralBoolean{isElementType}
-		},
-	},
-	{
-		Name: "and",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't use logical operator on %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-
-			if a.Type() == LiteralTypeBoolean && b.Type() == LiteralTypeBoolean {
-				av := a.(LiteralBoolean).Value
-				bv := b.(LiteralBoolean).Value
-
-				return LiteralBoolean{av && bv}
-			}
-			CreateEvaluateError(fmt.Sprintf("Can't use logical operator on %s and %s", a, b))
-			return nil
-		},
-	},
-	{
-		Name: "or",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				panic(fmt.Sprintf("Can't use logical operator on %s and %s", ae, be))
-			}
-			a := ae.(Literal)
-			b := be.(Literal)
-
-			if a.Type() == LiteralTypeBoolean && b.Type() == LiteralTypeBoolean {
-				av := a.(LiteralBoolean).Value
-				bv := b.(LiteralBoolean).Value
-
-				return LiteralBoolean{av || bv}
-			}
-			panic(fmt.Sprintf("Can't use logical operator on %s and %s", a, b))
+			return LiteralBoolean{Value: isElementType}, nil
 		},
 	},
 	{
 		Name: "xor",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
-			be := args[1].Eval(c)
-
-			if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't use logical operator on %s and %s", ae, be))
+		Args: []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			a, b, err := evalLiteralPair(c, args, "Can't use logical operator on")
+			if err != nil {
+				return nil, err
 			}
-			a := ae.(Literal)
-			b := be.(Literal)
 
 			if a.Type() == LiteralTypeBoolean && b.Type() == LiteralTypeBoolean {
 				av := a.(LiteralBoolean).Value
 				bv := b.(LiteralBoolean).Value
 
-				return LiteralBoolean{(av || bv) && !(av && bv)}
+				return LiteralBoolean{Value: (av || bv) && !(av && bv)}, nil
 			}
-			CreateEvaluateError(fmt.Sprintf("Can't use logical operator on %s and %s", a, b))
-			return nil
+			return nil, NewRuntimeError(ErrorTypeMismatch, "Can't use logical operator on %s and %s", a, b)
 		},
 	},
 	{
 		Name: "not",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
-			ae := args[0].Eval(c)
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			ae, err := args[0].Eval(c)
+			if err != nil {
+				return nil, err
+			}
 
 			if ae.ElementType() != ElementTypeLiteral {
-				CreateEvaluateError(fmt.Sprintf("Can't use logical operator on %s", ae))
+				return nil, NewRuntimeError(ErrorTypeMismatch, "Can't use logical operator on %s", ae)
 			}
 			a := ae.(Literal)
 
 			if a.Type() == LiteralTypeBoolean {
 				av := a.(LiteralBoolean).Value
 
-				return LiteralBoolean{!av}
+				return LiteralBoolean{Value: !av}, nil
 			}
-			CreateEvaluateError(fmt.Sprintf("Can't use logical operator on %s", a))
-			return nil
+			return nil, NewRuntimeError(ErrorTypeMismatch, "Can't use logical operator on %s", a)
 		},
 	},
 	{
 		Name: "head",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
 
 			if args[0].ElementType() != ElementTypeList {
-				CreateEvaluateError(fmt.Sprintf("Can't use list operators on %s", args[0]))
+				return nil, NewRuntimeError(ErrorTypeMismatch, "Can't use list operators on %s", args[0])
 			}
 
 			av := args[0].(List)
 
 			if len(av.GetElements()) <= 0 {
-				return LiteralNull{}
+				return LiteralNull{}, nil
 			} else {
-				return av.GetElements()[0]
+				return av.GetElements()[0], nil
 			}
 		},
 	},
 	{
 		Name: "tail",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
 			if args[0].ElementType() != ElementTypeList {
-				CreateEvaluateError(fmt.Sprintf("Can't use list operators on %s", args[0]))
+				return nil, NewRuntimeError(ErrorTypeMismatch, "Can't use list operators on %s", args[0])
 			}
 
 			av := args[0].(List)
 
 			if len(av.GetElements()) <= 0 {
-				return ListElement{}
+				return ListElement{}, nil
 			} else {
-				return ListElement{av.GetElements()[1:]}
+				return ListElement{Elements: av.GetElements()[1:]}, nil
 			}
 		},
 	},
 	{
 		Name: "cons",
-		Args: []Element{Atom{"a"}, Atom{"b"}},
-		Code: func(c *Context, args []Element) Element {
+		Args: []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Code: func(c *Context, args []Element) (Element, error) {
 			if args[1].ElementType() != ElementTypeList {
-				CreateEvaluateError(fmt.Sprintf("Can't use list operators on %s", args[1]))
+				return nil, NewRuntimeError(ErrorTypeMismatch, "Can't use list operators on %s", args[1])
 			}
 
 			bv := args[1].(List)
 
-			return ListElement{append([]Element{args[0]}, bv.GetElements()...)}
+			return ListElement{Elements: append([]Element{args[0]}, bv.GetElements()...)}, nil
 		},
 	},
 	{
 		Name: "eval",
-		Args: []Element{Atom{"a"}},
-		Code: func(c *Context, args []Element) Element {
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
 			return args[0].Eval(c)
 		},
 	},
@@ -527,3 +355,149 @@ func initBuiltins(c *Context) {
 		c.Add(b.Name, b)
 	}
 }
+
+// evalLiteralPair evaluates both arguments of a binary builtin, which must
+// be literals.
+func evalLiteralPair(c *Context, args []Element, verb string) (Literal, Literal, error) {
+	ae, err := args[0].Eval(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	be, err := args[1].Eval(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ae.ElementType() != ElementTypeLiteral || be.ElementType() != ElementTypeLiteral {
+		return nil, nil, NewRuntimeError(ErrorTypeMismatch, "%s %v and %v", verb, ae, be)
+	}
+	return ae.(Literal), be.(Literal), nil
+}
+
+// arithmetic folds op over the arguments from the left. A single argument
+// is combined with identity, so (minus x) is 0 - x, and no arguments give
+// identity.
+func arithmetic(c *Context, args []Element, verb string, identity Literal, op func(a, b Literal) (Element, error)) (Element, error) {
+	literals, err := evalLiterals(c, args, verb)
+	if err != nil {
+		return nil, err
+	}
+	if len(literals) == 0 {
+		return identity, nil
+	} else if len(literals) == 1 {
+		return op(identity, literals[0])
+	}
+
+	acc := literals[0]
+	for _, b := range literals[1:] {
+		res, err := op(acc, b)
+		if err != nil {
+			return nil, err
+		}
+		acc = res.(Literal)
+	}
+	return acc, nil
+}
+
+// compare applies op to each pair of neighbouring arguments, so
+// (less a b c) means a < b and b < c.
+func compare(c *Context, args []Element, verb string, op func(a, b Literal) (Element, error)) (Element, error) {
+	literals, err := evalLiterals(c, args, verb)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(literals); i++ {
+		res, err := op(literals[i-1], literals[i])
+		if err != nil {
+			return nil, err
+		}
+		if !res.(LiteralBoolean).Value {
+			return res, nil
+		}
+	}
+	return LiteralBoolean{Value: true}, nil
+}
+
+// evalLiterals evaluates the arguments of a builtin, which must be literals.
+func evalLiterals(c *Context, args []Element, verb string) ([]Literal, error) {
+	literals := make([]Literal, len(args))
+	for i, arg := range args {
+		ae, err := arg.Eval(c)
+		if err != nil {
+			return nil, err
+		}
+		if ae.ElementType() != ElementTypeLiteral {
+			return nil, NewRuntimeError(ErrorTypeMismatch, "%s %v", verb, ae)
+		}
+		literals[i] = ae.(Literal)
+	}
+	return literals, nil
+}
+
+func add(a, b Literal) (Element, error) {
+	return addition.apply(a, b)
+}
+
+func subtract(a, b Literal) (Element, error) {
+	return subtraction.apply(a, b)
+}
+
+func multiply(a, b Literal) (Element, error) {
+	return multiplication.apply(a, b)
+}
+
+func divide(a, b Literal) (Element, error) {
+	if k := commonKind(a, b); k != notNumber && k != kindReal && isExactZero(b) {
+		return nil, NewRuntimeError(ErrorDivisionByZero, "Can't divide %v by zero", a)
+	}
+	return division.apply(a, b)
+}
+
+func equal(a, b Literal) (Element, error) {
+	if res, ok := equality.apply(a, b); ok {
+		return res, nil
+	} else if a.Type() == LiteralTypeBoolean && b.Type() == LiteralTypeBoolean {
+		return LiteralBoolean{Value: a.(LiteralBoolean).Value == b.(LiteralBoolean).Value}, nil
+	} else if a.Type() == LiteralTypeString && b.Type() == LiteralTypeString {
+		return LiteralBoolean{Value: a.(LiteralString).Value == b.(LiteralString).Value}, nil
+	} else if a.Type() == LiteralTypeNull && b.Type() == LiteralTypeNull {
+		return LiteralBoolean{Value: true}, nil
+	}
+	return nil, NewRuntimeError(ErrorTypeMismatch, "Can't compare %s and %s", a, b)
+}
+
+func nonequal(a, b Literal) (Element, error) {
+	if res, ok := inequality.apply(a, b); ok {
+		return res, nil
+	} else if a.Type() == LiteralTypeBoolean && b.Type() == LiteralTypeBoolean {
+		return LiteralBoolean{Value: a.(LiteralBoolean).Value != b.(LiteralBoolean).Value}, nil
+	} else if a.Type() == LiteralTypeString && b.Type() == LiteralTypeString {
+		return LiteralBoolean{Value: a.(LiteralString).Value != b.(LiteralString).Value}, nil
+	} else if a.Type() == LiteralTypeNull && b.Type() == LiteralTypeNull {
+		return LiteralBoolean{Value: false}, nil
+	}
+	return nil, NewRuntimeError(ErrorTypeMismatch, "Can't compare %s and %s", a, b)
+}
+
+func less(a, b Literal) (Element, error) {
+	return order(lessThan, a, b)
+}
+
+func lessEq(a, b Literal) (Element, error) {
+	return order(lessOrEqual, a, b)
+}
+
+func greater(a, b Literal) (Element, error) {
+	return order(greaterThan, a, b)
+}
+
+func greaterEq(a, b Literal) (Element, error) {
+	return order(greaterOrEqual, a, b)
+}
+
+// order compares numbers, the only literals that are ordered.
+func order(op comparisonOp, a, b Literal) (Element, error) {
+	if res, ok := op.apply(a, b); ok {
+		return res, nil
+	}
+	return nil, NewRuntimeError(ErrorTypeMismatch, "Can't compare %s and %s", a, b)
+}
@@ -0,0 +1,125 @@
+package ast
+
+func init() {
+	Builtins = append(Builtins, mapBuiltins...)
+}
+
+var mapBuiltins = []Builtin{
+	{
+		Name: "get",
+		Args: []Element{Atom{Name: "m"}, Atom{Name: "key"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			m, err := mapArg("get", args[0])
+			if err != nil {
+				return nil, err
+			}
+			if v, ok := m.Get(args[1]); ok {
+				return v, nil
+			}
+			return LiteralNull{}, nil
+		},
+	},
+	{
+		Name: "put",
+		Args: []Element{Atom{Name: "m"}, Atom{Name: "key"}, Atom{Name: "value"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			m, err := mapArg("put", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return m.Put(args[1], args[2])
+		},
+	},
+	{
+		Name: "del",
+		Args: []Element{Atom{Name: "m"}, Atom{Name: "key"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			m, err := mapArg("del", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return m.Del(args[1]), nil
+		},
+	},
+	{
+		Name: "has",
+		Args: []Element{Atom{Name: "m"}, Atom{Name: "key"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			m, err := mapArg("has", args[0])
+			if err != nil {
+				return nil, err
+			}
+			_, ok := m.Get(args[1])
+			return LiteralBoolean{Value: ok}, nil
+		},
+	},
+	{
+		Name: "keys",
+		Args: []Element{Atom{Name: "m"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			m, err := mapArg("keys", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return ListElement{Elements: append([]Element(nil), m.Keys()...)}, nil
+		},
+	},
+	{
+		Name: "values",
+		Args: []Element{Atom{Name: "m"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			m, err := mapArg("values", args[0])
+			if err != nil {
+				return nil, err
+			}
+			var values []Element
+			for _, key := range m.Keys() {
+				v, _ := m.Get(key)
+				values = append(values, v)
+			}
+			return ListElement{Elements: values}, nil
+		},
+	},
+	{
+		Name: "size",
+		Args: []Element{Atom{Name: "m"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			m, err := mapArg("size", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return LiteralInteger{Value: int64(m.Len())}, nil
+		},
+	},
+	{
+		Name:     "merge",
+		Args:     []Element{Atom{Name: "maps"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			var res Map
+			for _, arg := range args {
+				m, err := mapArg("merge", arg)
+				if err != nil {
+					return nil, err
+				}
+				res = res.Merge(m)
+			}
+			return res, nil
+		},
+	},
+	{
+		Name: "ismap",
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			_, ok := args[0].(Map)
+			return LiteralBoolean{Value: ok}, nil
+		},
+	},
+}
+
+func mapArg(name string, e Element) (Map, error) {
+	if m, ok := e.(Map); ok {
+		return m, nil
+	}
+	return Map{}, NewRuntimeError(ErrorTypeMismatch, "%s expects a map, got %v", name, e)
+}
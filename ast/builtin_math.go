@@ -0,0 +1,208 @@
+package ast
+
+import (
+	"math"
+	"math/big"
+)
+
+func init() {
+	Builtins = append(Builtins, mathBuiltins...)
+}
+
+var mathBuiltins = []Builtin{
+	constant("pi", math.Pi),
+	constant("e", math.E),
+	realFunction("sin", math.Sin),
+	realFunction("cos", math.Cos),
+	realFunction("tan", math.Tan),
+	realFunction("exp", math.Exp),
+	realFunction("log", math.Log),
+	realFunction("log10", math.Log10),
+	{
+		Name: "atan2",
+		Args: []Element{Atom{Name: "y"}, Atom{Name: "x"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			return realFunction2(c, args, "atan2", math.Atan2)
+		},
+	},
+	{
+		Name: "pow",
+		Args: []Element{Atom{Name: "base"}, Atom{Name: "exponent"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			return realFunction2(c, args, "pow", math.Pow)
+		},
+	},
+	{
+		Name:     "gcd",
+		Args:     []Element{Atom{Name: "integers"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return foldIntegers(c, args, "gcd", big.NewInt(0), func(z, a, b *big.Int) *big.Int {
+				z.GCD(nil, nil, new(big.Int).Abs(a), new(big.Int).Abs(b))
+				return z
+			})
+		},
+	},
+	{
+		Name:     "lcm",
+		Args:     []Element{Atom{Name: "integers"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return foldIntegers(c, args, "lcm", big.NewInt(1), func(z, a, b *big.Int) *big.Int {
+				if a.Sign() == 0 || b.Sign() == 0 {
+					return z.SetInt64(0)
+				}
+				gcd := new(big.Int).GCD(nil, nil, new(big.Int).Abs(a), new(big.Int).Abs(b))
+				z.Mul(a, b).Abs(z)
+				return z.Quo(z, gcd)
+			})
+		},
+	},
+	{
+		Name:     "bitand",
+		Args:     []Element{Atom{Name: "integers"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return foldIntegers(c, args, "bitand", big.NewInt(-1), (*big.Int).And)
+		},
+	},
+	{
+		Name:     "bitor",
+		Args:     []Element{Atom{Name: "integers"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return foldIntegers(c, args, "bitor", big.NewInt(0), (*big.Int).Or)
+		},
+	},
+	{
+		Name:     "bitxor",
+		Args:     []Element{Atom{Name: "integers"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return foldIntegers(c, args, "bitxor", big.NewInt(0), (*big.Int).Xor)
+		},
+	},
+	{
+		// (shift n k) shifts n left by k bits, or right if k is negative
+		Name: "shift",
+		Args: []Element{Atom{Name: "n"}, Atom{Name: "k"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			n, err := evalInteger(c, args[0], "shift")
+			if err != nil {
+				return nil, err
+			}
+			k, err := evalInteger(c, args[1], "shift")
+			if err != nil {
+				return nil, err
+			}
+			if !k.IsInt64() || k.Int64() > math.MaxInt32 || k.Int64() < math.MinInt32 {
+				return nil, NewRuntimeError(ErrorRuntime, "shift: can't shift by %v bits", k)
+			}
+			if bits := k.Int64(); bits < 0 {
+				return integer(new(big.Int).Rsh(n, uint(-bits))), nil
+			} else if n.Sign() != 0 && int64(n.BitLen())+bits > maxBits {
+				return nil, NewRuntimeError(ErrorRuntime, "shift: %v shifted by %v bits would have more than %d bits", n, k, maxBits)
+			}
+			return integer(new(big.Int).Lsh(n, uint(k.Int64()))), nil
+		},
+	},
+	{
+		Name: "random",
+		Code: func(c *Context, args []Element) (Element, error) {
+			return LiteralReal{Value: c.Runtime.Random.Float64()}, nil
+		},
+	},
+	{
+		// (random-int n) is one of 0 to n - 1
+		Name: "random-int",
+		Args: []Element{Atom{Name: "n"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			n, err := evalInteger(c, args[0], "random-int")
+			if err != nil {
+				return nil, err
+			}
+			if n.Sign() <= 0 {
+				return nil, NewRuntimeError(ErrorTypeMismatch, "random-int expects a positive integer, got %v", n)
+			}
+			return integer(new(big.Int).Rand(c.Runtime.Random, n)), nil
+		},
+	},
+	{
+		Name: "random-seed",
+		Args: []Element{Atom{Name: "seed"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			seed, err := evalInteger(c, args[0], "random-seed")
+			if err != nil {
+				return nil, err
+			}
+			if !seed.IsInt64() {
+				return nil, NewRuntimeError(ErrorRuntime, "random-seed: can't seed with %v, it does not fit in 64 bits", seed)
+			}
+			c.Runtime.Random.Seed(seed.Int64())
+			return LiteralNull{}, nil
+		},
+	},
+}
+
+// constant returns a builtin without arguments giving x, as (pi).
+func constant(name string, x float64) Builtin {
+	return Builtin{
+		Name: name,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return LiteralReal{Value: x}, nil
+		},
+	}
+}
+
+// realFunction returns a builtin computing f on a number converted to a
+// real.
+func realFunction(name string, f func(float64) float64) Builtin {
+	return Builtin{
+		Name: name,
+		Args: []Element{Atom{Name: "x"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			x, err := evalNumber(c, args[0], name)
+			if err != nil {
+				return nil, err
+			}
+			return LiteralReal{Value: f(toFloat(x))}, nil
+		},
+	}
+}
+
+func realFunction2(c *Context, args []Element, name string, f func(a, b float64) float64) (Element, error) {
+	a, err := evalNumber(c, args[0], name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := evalNumber(c, args[1], name)
+	if err != nil {
+		return nil, err
+	}
+	return LiteralReal{Value: f(toFloat(a), toFloat(b))}, nil
+}
+
+// foldIntegers folds op over integer arguments, starting from identity.
+func foldIntegers(c *Context, args []Element, name string, identity *big.Int, op func(z, a, b *big.Int) *big.Int) (Element, error) {
+	acc := identity
+	for _, arg := range args {
+		n, err := evalInteger(c, arg, name)
+		if err != nil {
+			return nil, err
+		}
+		acc = op(new(big.Int), acc, n)
+	}
+	return integer(acc), nil
+}
+
+// evalInteger evaluates the argument of a builtin, which must be an integer.
+func evalInteger(c *Context, arg Element, name string) (*big.Int, error) {
+	n, err := evalNumber(c, arg, name)
+	if err != nil {
+		return nil, err
+	}
+	if k := kindOf(n); k != kindInteger && k != kindBigInteger {
+		return nil, NewRuntimeError(ErrorTypeMismatch, "%s expects an integer, got %v", name, n)
+	}
+	return toBig(n), nil
+}
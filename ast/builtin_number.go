@@ -0,0 +1,295 @@
+package ast
+
+import (
+	"math"
+	"math/big"
+)
+
+func init() {
+	Builtins = append(Builtins, numberBuiltins...)
+}
+
+// Exact division by zero raises a division by zero error, real division
+// follows IEEE 754 and gives infinities and NaNs. Builtins on numbers give
+// exact results for exact arguments where the result is exact, and reals if
+// an argument is real.
+var numberBuiltins = []Builtin{
+	{
+		Name: "isnan",
+		Args: []Element{Atom{Name: "n"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			n, err := evalNumber(c, args[0], "isnan")
+			if err != nil {
+				return nil, err
+			}
+			r, ok := n.(LiteralReal)
+			return LiteralBoolean{Value: ok && math.IsNaN(r.Value)}, nil
+		},
+	},
+	{
+		Name: "isinf",
+		Args: []Element{Atom{Name: "n"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			n, err := evalNumber(c, args[0], "isinf")
+			if err != nil {
+				return nil, err
+			}
+			r, ok := n.(LiteralReal)
+			return LiteralBoolean{Value: ok && math.IsInf(r.Value, 0)}, nil
+		},
+	},
+	{
+		Name: "quotient",
+		Args: []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Code: integerDivision("quotient", arithmeticOp{
+			verb: "Can't divide",
+			integer: func(a, b int64) (int64, bool) {
+				return a / b, !(a == math.MinInt64 && b == -1)
+			},
+			big:  (*big.Int).Quo,
+			real: func(a, b float64) float64 { return math.Trunc(a / b) },
+		}),
+	},
+	{
+		Name: "rem",
+		Args: []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Code: integerDivision("rem", arithmeticOp{
+			verb: "Can't divide",
+			integer: func(a, b int64) (int64, bool) {
+				return a % b, true
+			},
+			big:  (*big.Int).Rem,
+			real: math.Mod,
+		}),
+	},
+	{
+		Name: "mod",
+		Args: []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Code: integerDivision("mod", arithmeticOp{
+			verb: "Can't divide",
+			integer: func(a, b int64) (int64, bool) {
+				m := a % b
+				if m != 0 && (m < 0) != (b < 0) {
+					m += b
+				}
+				return m, true
+			},
+			big: func(z, a, b *big.Int) *big.Int {
+				z.Rem(a, b)
+				if z.Sign() != 0 && z.Sign() != b.Sign() {
+					z.Add(z, b)
+				}
+				return z
+			},
+			real: func(a, b float64) float64 {
+				m := math.Mod(a, b)
+				if m != 0 && (m < 0) != (b < 0) {
+					m += b
+				}
+				return m
+			},
+		}),
+	},
+	{
+		Name: "abs",
+		Args: []Element{Atom{Name: "n"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			n, err := evalNumber(c, args[0], "abs")
+			if err != nil {
+				return nil, err
+			}
+			if r, ok := n.(LiteralReal); ok {
+				return LiteralReal{Value: math.Abs(r.Value)}, nil
+			}
+			if negative, _ := lessThan.apply(n, LiteralInteger{}); negative.(LiteralBoolean).Value {
+				return subtract(LiteralInteger{}, n)
+			}
+			return n, nil
+		},
+	},
+	{
+		Name:     "min",
+		Args:     []Element{Atom{Name: "n"}, Atom{Name: "numbers"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return extremum(c, args, "min", lessThan)
+		},
+	},
+	{
+		Name:     "max",
+		Args:     []Element{Atom{Name: "n"}, Atom{Name: "numbers"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			return extremum(c, args, "max", greaterThan)
+		},
+	},
+	{
+		Name: "floor",
+		Args: []Element{Atom{Name: "n"}},
+		Code: rounding("floor", func(r *big.Rat) *big.Int {
+			return new(big.Int).Div(r.Num(), r.Denom())
+		}, math.Floor),
+	},
+	{
+		Name: "ceil",
+		Args: []Element{Atom{Name: "n"}},
+		Code: rounding("ceil", func(r *big.Rat) *big.Int {
+			n := new(big.Int).Div(new(big.Int).Neg(r.Num()), r.Denom())
+			return n.Neg(n)
+		}, math.Ceil),
+	},
+	{
+		Name: "round",
+		Args: []Element{Atom{Name: "n"}},
+		// Halves are rounded to the even neighbour
+		Code: rounding("round", func(r *big.Rat) *big.Int {
+			half := new(big.Rat).Add(r, big.NewRat(1, 2))
+			n := new(big.Int).Div(half.Num(), half.Denom())
+			if half.IsInt() && n.Bit(0) == 1 {
+				n.Sub(n, big.NewInt(1))
+			}
+			return n
+		}, math.RoundToEven),
+	},
+	{
+		Name: "truncate",
+		Args: []Element{Atom{Name: "n"}},
+		Code: rounding("truncate", func(r *big.Rat) *big.Int {
+			return new(big.Int).Quo(r.Num(), r.Denom())
+		}, math.Trunc),
+	},
+	{
+		Name: "expt",
+		Args: []Element{Atom{Name: "base"}, Atom{Name: "exponent"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			base, err := evalNumber(c, args[0], "expt")
+			if err != nil {
+				return nil, err
+			}
+			exponent, err := evalNumber(c, args[1], "expt")
+			if err != nil {
+				return nil, err
+			}
+			return power(base, exponent)
+		},
+	},
+	{
+		Name: "sqrt",
+		Args: []Element{Atom{Name: "n"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			n, err := evalNumber(c, args[0], "sqrt")
+			if err != nil {
+				return nil, err
+			}
+			return squareRoot(n), nil
+		},
+	},
+}
+
+// integerDivision returns the code of a builtin dividing integers, or reals.
+func integerDivision(name string, op arithmeticOp) func(*Context, []Element) (Element, error) {
+	return func(c *Context, args []Element) (Element, error) {
+		a, err := evalNumber(c, args[0], name)
+		if err != nil {
+			return nil, err
+		}
+		b, err := evalNumber(c, args[1], name)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range []Literal{a, b} {
+			if kindOf(n) == kindRational {
+				return nil, NewRuntimeError(ErrorTypeMismatch, "%s expects integers or reals, got %v", name, n)
+			}
+		}
+		if commonKind(a, b) != kindReal && isExactZero(b) {
+			return nil, NewRuntimeError(ErrorDivisionByZero, "Can't divide %v by zero", a)
+		}
+		return op.apply(a, b)
+	}
+}
+
+// extremum returns the argument that is op to all others. It is real if
+// any argument is.
+func extremum(c *Context, args []Element, name string, op comparisonOp) (Element, error) {
+	var res Literal
+	inexact := false
+	for _, arg := range args {
+		n, err := evalNumber(c, arg, name)
+		if err != nil {
+			return nil, err
+		}
+		inexact = inexact || kindOf(n) == kindReal
+		if res == nil {
+			res = n
+		} else if better, _ := op.apply(n, res); better.(LiteralBoolean).Value {
+			res = n
+		}
+	}
+	if inexact {
+		return LiteralReal{Value: toFloat(res)}, nil
+	}
+	return res, nil
+}
+
+// rounding returns the code of a builtin rounding a number to an integer,
+// with exact for exact numbers and real for reals.
+func rounding(name string, exact func(r *big.Rat) *big.Int, real func(float64) float64) func(*Context, []Element) (Element, error) {
+	return func(c *Context, args []Element) (Element, error) {
+		n, err := evalNumber(c, args[0], name)
+		if err != nil {
+			return nil, err
+		}
+		switch n := n.(type) {
+		case LiteralRational:
+			return integer(exact(n.Value)), nil
+		case LiteralReal:
+			return LiteralReal{Value: real(n.Value)}, nil
+		}
+		return n, nil
+	}
+}
+
+// power raises base to exponent. Exact bases raised to integer exponents
+// give exact results.
+func power(base, exponent Literal) (Element, error) {
+	e, ok := exponent.(LiteralInteger)
+	if !ok || e.Value == math.MinInt64 || kindOf(base) == kindReal {
+		return LiteralReal{Value: math.Pow(toFloat(base), toFloat(exponent))}, nil
+	}
+	r := toRat(base)
+	n := e.Value
+	if n < 0 {
+		if r.Sign() == 0 {
+			return nil, NewRuntimeError(ErrorDivisionByZero, "Can't raise 0 to the negative power %v", e)
+		}
+		r, n = new(big.Rat).Inv(r), -n
+	}
+	// The result has at least n*(bits-1) bits, bits being the size of the
+	// larger of the numerator and the denominator
+	bits := r.Num().BitLen()
+	if d := r.Denom().BitLen(); d > bits {
+		bits = d
+	}
+	if bits > 1 && n > maxBits/int64(bits-1) {
+		return nil, NewRuntimeError(ErrorRuntime, "expt: %v to the power %v would have more than %d bits", base, exponent, maxBits)
+	}
+	num := new(big.Int).Exp(r.Num(), big.NewInt(n), nil)
+	den := new(big.Int).Exp(r.Denom(), big.NewInt(n), nil)
+	return NewRational(Span{}, new(big.Rat).SetFrac(num, den)), nil
+}
+
+// squareRoot returns the square root of n, exact if n is the square of an
+// exact number.
+func squareRoot(n Literal) Element {
+	if kindOf(n) != kindReal {
+		r := toRat(n)
+		if r.Sign() >= 0 {
+			num, den := new(big.Int).Sqrt(r.Num()), new(big.Int).Sqrt(r.Denom())
+			if new(big.Int).Mul(num, num).Cmp(r.Num()) == 0 && new(big.Int).Mul(den, den).Cmp(r.Denom()) == 0 {
+				return NewRational(Span{}, new(big.Rat).SetFrac(num, den))
+			}
+		}
+	}
+	return LiteralReal{Value: math.Sqrt(toFloat(n))}
+}
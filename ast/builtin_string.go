@@ -0,0 +1,226 @@
+package ast
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/flychario/flylang/scanner"
+	"github.com/flychario/flylang/token"
+)
+
+func init() {
+	Builtins = append(Builtins, stringBuiltins...)
+}
+
+var stringBuiltins = []Builtin{
+	{
+		Name: "concat",
+		Args: []Element{Atom{Name: "a"}, Atom{Name: "b"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			a, err := stringArg("concat", args[0])
+			if err != nil {
+				return nil, err
+			}
+			b, err := stringArg("concat", args[1])
+			if err != nil {
+				return nil, err
+			}
+			return LiteralString{Value: a + b}, nil
+		},
+	},
+	{
+		Name: "length",
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			if lst, ok := args[0].(List); ok {
+				return LiteralInteger{Value: int64(len(lst.GetElements()))}, nil
+			}
+			a, err := stringArg("length", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return LiteralInteger{Value: int64(len([]rune(a)))}, nil
+		},
+	},
+	{
+		Name: "substring",
+		Args: []Element{Atom{Name: "s"}, Atom{Name: "start"}, Atom{Name: "end"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			s, err := stringArg("substring", args[0])
+			if err != nil {
+				return nil, err
+			}
+			start, err := integerArg("substring", args[1])
+			if err != nil {
+				return nil, err
+			}
+			end, err := integerArg("substring", args[2])
+			if err != nil {
+				return nil, err
+			}
+			runes := []rune(s)
+			if start < 0 || end < start || end > int64(len(runes)) {
+				return nil, NewRuntimeError(ErrorRuntime, "substring range %d:%d out of bounds for %v", start, end, args[0])
+			}
+			return LiteralString{Value: string(runes[start:end])}, nil
+		},
+	},
+	{
+		Name: "index-of",
+		Args: []Element{Atom{Name: "s"}, Atom{Name: "sub"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			s, err := stringArg("index-of", args[0])
+			if err != nil {
+				return nil, err
+			}
+			sub, err := stringArg("index-of", args[1])
+			if err != nil {
+				return nil, err
+			}
+			i := strings.Index(s, sub)
+			if i < 0 {
+				return LiteralInteger{Value: -1}, nil
+			}
+			return LiteralInteger{Value: int64(len([]rune(s[:i])))}, nil
+		},
+	},
+	{
+		Name: "split",
+		Args: []Element{Atom{Name: "s"}, Atom{Name: "sep"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			s, err := stringArg("split", args[0])
+			if err != nil {
+				return nil, err
+			}
+			sep, err := stringArg("split", args[1])
+			if err != nil {
+				return nil, err
+			}
+			var parts []Element
+			for _, part := range strings.Split(s, sep) {
+				parts = append(parts, LiteralString{Value: part})
+			}
+			return ListElement{Elements: parts}, nil
+		},
+	},
+	{
+		Name: "join",
+		Args: []Element{Atom{Name: "list"}, Atom{Name: "sep"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			lst, ok := args[0].(List)
+			if !ok {
+				return nil, NewRuntimeError(ErrorTypeMismatch, "join expects a list, got %v", args[0])
+			}
+			sep, err := stringArg("join", args[1])
+			if err != nil {
+				return nil, err
+			}
+			parts := make([]string, len(lst.GetElements()))
+			for i, elem := range lst.GetElements() {
+				if parts[i], err = stringArg("join", elem); err != nil {
+					return nil, err
+				}
+			}
+			return LiteralString{Value: strings.Join(parts, sep)}, nil
+		},
+	},
+	{
+		Name: "upcase",
+		Args: []Element{Atom{Name: "s"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			s, err := stringArg("upcase", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return LiteralString{Value: strings.ToUpper(s)}, nil
+		},
+	},
+	{
+		Name: "downcase",
+		Args: []Element{Atom{Name: "s"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			s, err := stringArg("downcase", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return LiteralString{Value: strings.ToLower(s)}, nil
+		},
+	},
+	{
+		Name: "string->number",
+		Args: []Element{Atom{Name: "s"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			s, err := stringArg("string->number", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return parseNumber(strings.TrimSpace(s)), nil
+		},
+	},
+	{
+		Name: "number->string",
+		Args: []Element{Atom{Name: "n"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			switch n := args[0].(type) {
+			case LiteralInteger:
+				return LiteralString{Value: n.String()}, nil
+			case LiteralBigInteger:
+				return LiteralString{Value: n.String()}, nil
+			case LiteralRational:
+				return LiteralString{Value: n.String()}, nil
+			case LiteralReal:
+				return LiteralString{Value: n.String()}, nil
+			}
+			return nil, NewRuntimeError(ErrorTypeMismatch, "number->string expects a number, got %v", args[0])
+		},
+	},
+}
+
+// parseNumber returns the number s is written as in flylang source, or false
+// if s is not a number.
+func parseNumber(s string) Element {
+	// The scanner panics on characters that can't be in source
+	if !utf8.ValidString(s) || strings.ContainsRune(s, 0) {
+		return LiteralBoolean{Value: false}
+	}
+	var sc scanner.Scanner
+	sc.Init([]byte(s))
+	_, tok, lit := sc.Scan()
+	if sc.Pos().Offset != len(s) {
+		return LiteralBoolean{Value: false}
+	}
+	switch tok {
+	case token.INTEGER:
+		if i, err := strconv.ParseInt(lit, 10, 64); err == nil {
+			return LiteralInteger{Value: i}
+		}
+		if i, ok := new(big.Int).SetString(lit, 10); ok {
+			return LiteralBigInteger{Value: i}
+		}
+	case token.REAL:
+		if r, err := ParseReal(lit); err == nil {
+			return LiteralReal{Value: r}
+		}
+	case token.RATIONAL:
+		if r, ok := new(big.Rat).SetString(lit); ok {
+			return NewRational(Span{}, r)
+		}
+	}
+	return LiteralBoolean{Value: false}
+}
+
+func stringArg(name string, arg Element) (string, error) {
+	if s, ok := arg.(LiteralString); ok {
+		return s.Value, nil
+	}
+	return "", NewRuntimeError(ErrorTypeMismatch, "%s expects a string, got %v", name, arg)
+}
+
+func integerArg(name string, arg Element) (int64, error) {
+	if i, ok := arg.(LiteralInteger); ok {
+		return i.Value, nil
+	}
+	return 0, NewRuntimeError(ErrorTypeMismatch, "%s expects an integer, got %v", name, arg)
+}
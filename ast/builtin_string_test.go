@@ -0,0 +1,41 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/flychario/flylang/ast"
+)
+
+func TestStringToNumber(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"integer", `"42"`, "42"},
+		{"plus sign", `"+42"`, "42"},
+		{"minus sign", `"-42"`, "-42"},
+		{"big integer", `"123456789012345678901234567890"`, "123456789012345678901234567890"},
+		{"real", `"2.5"`, "2.5"},
+		{"rational", `" 1/2 "`, "1/2"},
+		{"whole rational", `"4/2"`, "2"},
+		{"infinity", `"inf"`, "false"},
+		{"hexadecimal float", `"0x1p-2"`, "false"},
+		{"exponent", `"1e3"`, "false"},
+		{"underscores", `"1_000"`, "false"},
+		{"two numbers", `"1 2"`, "false"},
+		{"comment", `"1;2"`, "false"},
+		{"empty", `""`, "false"},
+		{"NUL", `"1\u0000"`, "false"},
+		{"invalid UTF-8", `"1\xff"`, "false"},
+		{"word", `"abc"`, "false"},
+	} {
+		src := "(string->number " + test.input + ")"
+		res, err := parse("test", []byte(src)).Eval(ast.GetGlobalContext())
+		if err != nil {
+			t.Errorf("%s: %s: %v", test.name, src, err)
+		} else if ast.Print(res) != test.want {
+			t.Errorf("%s: %s: expected %s, got %s", test.name, src, test.want, ast.Print(res))
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package ast
+
+func init() {
+	Builtins = append(Builtins, vectorBuiltins...)
+}
+
+var vectorBuiltins = []Builtin{
+	{
+		Name: "make-vector",
+		Args: []Element{Atom{Name: "n"}, Atom{Name: "fill"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			n, err := integerArg("make-vector", args[0])
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 {
+				return nil, NewRuntimeError(ErrorRuntime, "make-vector can't make a vector of length %d", n)
+			}
+			elements := make([]Element, n)
+			for i := range elements {
+				elements[i] = args[1]
+			}
+			return Vector{Elements: elements}, nil
+		},
+	},
+	{
+		Name: "vector-ref",
+		Args: []Element{Atom{Name: "v"}, Atom{Name: "i"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			v, i, err := vectorIndex("vector-ref", args)
+			if err != nil {
+				return nil, err
+			}
+			return v.Elements[i], nil
+		},
+	},
+	{
+		Name: "vector-set",
+		Args: []Element{Atom{Name: "v"}, Atom{Name: "i"}, Atom{Name: "value"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			v, i, err := vectorIndex("vector-set", args)
+			if err != nil {
+				return nil, err
+			}
+			if v.Constant {
+				return nil, NewRuntimeError(ErrorRuntime, "vector-set: can't change the constant vector %v", v)
+			}
+			v.Elements[i] = args[2]
+			return v, nil
+		},
+	},
+	{
+		Name: "vector-length",
+		Args: []Element{Atom{Name: "v"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			v, err := vectorArg("vector-length", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return LiteralInteger{Value: int64(len(v.Elements))}, nil
+		},
+	},
+	{
+		Name:     "vector-append",
+		Args:     []Element{Atom{Name: "vectors"}},
+		Variadic: true,
+		Code: func(c *Context, args []Element) (Element, error) {
+			elements := []Element{}
+			for _, arg := range args {
+				v, err := vectorArg("vector-append", arg)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, v.Elements...)
+			}
+			return Vector{Elements: elements}, nil
+		},
+	},
+	{
+		Name: "subvector",
+		Args: []Element{Atom{Name: "v"}, Atom{Name: "start"}, Atom{Name: "end"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			v, err := vectorArg("subvector", args[0])
+			if err != nil {
+				return nil, err
+			}
+			start, err := integerArg("subvector", args[1])
+			if err != nil {
+				return nil, err
+			}
+			end, err := integerArg("subvector", args[2])
+			if err != nil {
+				return nil, err
+			}
+			if start < 0 || end < start || end > int64(len(v.Elements)) {
+				return nil, NewRuntimeError(ErrorRuntime, "subvector range %d:%d out of bounds for a vector of length %d", start, end, len(v.Elements))
+			}
+			return Vector{Elements: append([]Element{}, v.Elements[start:end]...)}, nil
+		},
+	},
+	{
+		Name: "list->vector",
+		Args: []Element{Atom{Name: "list"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			lst, ok := args[0].(ListElement)
+			if !ok {
+				return nil, NewRuntimeError(ErrorTypeMismatch, "list->vector expects a list, got %v", args[0])
+			}
+			return Vector{Elements: append([]Element{}, lst.Elements...)}, nil
+		},
+	},
+	{
+		Name: "vector->list",
+		Args: []Element{Atom{Name: "v"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			v, err := vectorArg("vector->list", args[0])
+			if err != nil {
+				return nil, err
+			}
+			if len(v.Elements) == 0 {
+				return ListElement{}, nil
+			}
+			return ListElement{Elements: append([]Element{}, v.Elements...)}, nil
+		},
+	},
+	{
+		Name: "isvector",
+		Args: []Element{Atom{Name: "a"}},
+		Code: func(c *Context, args []Element) (Element, error) {
+			_, ok := args[0].(Vector)
+			return LiteralBoolean{Value: ok}, nil
+		},
+	},
+}
+
+func vectorArg(name string, e Element) (Vector, error) {
+	if v, ok := e.(Vector); ok {
+		return v, nil
+	}
+	return Vector{}, NewRuntimeError(ErrorTypeMismatch, "%s expects a vector, got %v", name, e)
+}
+
+// vectorIndex returns the vector and the index in it given by the first two
+// arguments of a call to name.
+func vectorIndex(name string, args []Element) (Vector, int64, error) {
+	v, err := vectorArg(name, args[0])
+	if err != nil {
+		return Vector{}, 0, err
+	}
+	i, err := integerArg(name, args[1])
+	if err != nil {
+		return Vector{}, 0, err
+	}
+	if i < 0 || i >= int64(len(v.Elements)) {
+		return Vector{}, 0, NewRuntimeError(ErrorRuntime, "%s index %d out of bounds for a vector of length %d", name, i, len(v.Elements))
+	}
+	return v, i, nil
+}
@@ -0,0 +1,185 @@
+package ast
+
+import "math"
+
+// Equal reports whether a and b are the same element. Source positions are
+// not taken into account, and reals that are NaN are the same.
+func Equal(a, b Element) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	switch a := a.(type) {
+	case Atom:
+		b, ok := b.(Atom)
+		return ok && a.Name == b.Name
+	case LiteralInteger:
+		b, ok := b.(LiteralInteger)
+		return ok && a.Value == b.Value
+	case LiteralBigInteger:
+		b, ok := b.(LiteralBigInteger)
+		return ok && a.Value.Cmp(b.Value) == 0
+	case LiteralRational:
+		b, ok := b.(LiteralRational)
+		return ok && a.Value.Cmp(b.Value) == 0
+	case LiteralReal:
+		b, ok := b.(LiteralReal)
+		return ok && (a.Value == b.Value || math.IsNaN(a.Value) && math.IsNaN(b.Value))
+	case LiteralBoolean:
+		b, ok := b.(LiteralBoolean)
+		return ok && a.Value == b.Value
+	case LiteralNull:
+		_, ok := b.(LiteralNull)
+		return ok
+	case LiteralString:
+		b, ok := b.(LiteralString)
+		return ok && a.Value == b.Value
+	case LiteralList:
+		b, ok := b.(LiteralList)
+		if !ok || len(a.Value) != len(b.Value) {
+			return false
+		}
+		for i := range a.Value {
+			if !Equal(a.Value[i], b.Value[i]) {
+				return false
+			}
+		}
+		return true
+	case ListElement:
+		b, ok := b.(ListElement)
+		return ok && equalElements(a.Elements, b.Elements)
+	case MapElement:
+		b, ok := b.(MapElement)
+		return ok && equalElements(a.Elements, b.Elements)
+	case Map:
+		b, ok := b.(Map)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		equal := true
+		a.root.each(func(e *mapEntry) {
+			if equal {
+				other, ok := b.root.get(e.key, e.hash)
+				equal = ok && Equal(e.value, other.value)
+			}
+		})
+		return equal
+	case VectorElement:
+		b, ok := b.(VectorElement)
+		return ok && equalElements(a.Elements, b.Elements)
+	case Vector:
+		b, ok := b.(Vector)
+		return ok && equalElements(a.Elements, b.Elements)
+	case Program:
+		b, ok := b.(Program)
+		return ok && equalElements(a.Elements, b.Elements)
+	case Quote:
+		b, ok := b.(Quote)
+		return ok && Equal(a.Element, b.Element)
+	case Quasiquote:
+		b, ok := b.(Quasiquote)
+		return ok && Equal(a.Element, b.Element)
+	case Unquote:
+		b, ok := b.(Unquote)
+		return ok && Equal(a.Element, b.Element)
+	case UnquoteSplicing:
+		b, ok := b.(UnquoteSplicing)
+		return ok && Equal(a.Element, b.Element)
+	case Setq:
+		b, ok := b.(Setq)
+		return ok && Equal(a.Atom, b.Atom) && Equal(a.Element, b.Element)
+	case Func:
+		b, ok := b.(Func)
+		return ok && Equal(a.Atom, b.Atom) && Equal(a.List, b.List) && Equal(a.SubProg, b.SubProg)
+	case Lambda:
+		b, ok := b.(Lambda)
+		return ok && a.Context == b.Context && Equal(a.List, b.List) && Equal(a.SubProg, b.SubProg)
+	case *Lambda:
+		b, ok := b.(*Lambda)
+		return ok && Equal(*a, *b)
+	case Prog:
+		b, ok := b.(Prog)
+		return ok && Equal(a.List, b.List) && Equal(a.SubProg, b.SubProg)
+	case *Prog:
+		b, ok := b.(*Prog)
+		return ok && Equal(*a, *b)
+	case Cond:
+		b, ok := b.(Cond)
+		if !ok || len(a.Clauses) != len(b.Clauses) {
+			return false
+		}
+		for i := range a.Clauses {
+			if !Equal(a.Clauses[i].Test, b.Clauses[i].Test) || !Equal(a.Clauses[i].Body, b.Clauses[i].Body) {
+				return false
+			}
+		}
+		return true
+	case If:
+		b, ok := b.(If)
+		return ok && Equal(a.Test, b.Test) && Equal(a.Then, b.Then) && Equal(a.Else, b.Else)
+	case Let:
+		b, ok := b.(Let)
+		if !ok || a.Kind != b.Kind || len(a.Bindings) != len(b.Bindings) {
+			return false
+		}
+		for i := range a.Bindings {
+			if !Equal(a.Bindings[i].Name, b.Bindings[i].Name) || !Equal(a.Bindings[i].Value, b.Bindings[i].Value) {
+				return false
+			}
+		}
+		return Equal(a.Body, b.Body)
+	case Logical:
+		b, ok := b.(Logical)
+		return ok && a.Kind == b.Kind && equalElements(a.Elements, b.Elements)
+	case When:
+		b, ok := b.(When)
+		return ok && a.Kind == b.Kind && Equal(a.Test, b.Test) && Equal(a.Body, b.Body)
+	case DefMacro:
+		b, ok := b.(DefMacro)
+		return ok && Equal(a.Atom, b.Atom) && Equal(a.List, b.List) && Equal(a.SubProg, b.SubProg)
+	case DefineSyntax:
+		b, ok := b.(DefineSyntax)
+		if !ok || !Equal(a.Atom, b.Atom) || len(a.Literals) != len(b.Literals) || len(a.Rules) != len(b.Rules) {
+			return false
+		}
+		for i := range a.Literals {
+			if !Equal(a.Literals[i], b.Literals[i]) {
+				return false
+			}
+		}
+		for i := range a.Rules {
+			if !Equal(a.Rules[i].Pattern, b.Rules[i].Pattern) || !Equal(a.Rules[i].Template, b.Rules[i].Template) {
+				return false
+			}
+		}
+		return true
+	case While:
+		b, ok := b.(While)
+		return ok && Equal(a.Element1, b.Element1) && Equal(a.Element2, b.Element2)
+	case Return:
+		b, ok := b.(Return)
+		return ok && Equal(a.Element, b.Element)
+	case Break:
+		_, ok := b.(Break)
+		return ok
+	case Builtin:
+		b, ok := b.(Builtin)
+		return ok && a.Name == b.Name
+	case *Builtin:
+		b, ok := b.(*Builtin)
+		return ok && a.Name == b.Name
+	}
+	return false
+}
+
+func equalElements(a, b []Element) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flychario/flylang/token"
+)
+
+type ErrorKind int
+
+const (
+	ErrorRuntime ErrorKind = iota
+	ErrorUndefinedVariable
+	ErrorArityMismatch
+	ErrorTypeMismatch
+	ErrorDivisionByZero
+	ErrorSyntax
+)
+
+var errorKinds = [...]string{
+	ErrorRuntime:           "runtime error",
+	ErrorUndefinedVariable: "undefined variable",
+	ErrorArityMismatch:     "arity mismatch",
+	ErrorTypeMismatch:      "type mismatch",
+	ErrorDivisionByZero:    "division by zero",
+	ErrorSyntax:            "syntax error",
+}
+
+func (k ErrorKind) String() string {
+	return errorKinds[k]
+}
+
+// Frame is a function call that was active when a runtime error occurred.
+type Frame struct {
+	Function string
+	Pos      token.Position // position of the call
+}
+
+// RuntimeError is an error raised while evaluating a flylang program.
+type RuntimeError struct {
+	Kind    ErrorKind
+	Message string
+	Pos     token.Position // position of the failing element
+	Stack   []Frame        // active calls, innermost first
+	Err     error          // underlying error, if any
+}
+
+func NewRuntimeError(kind ErrorKind, format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RuntimeError) at(pos token.Position) *RuntimeError {
+	e.Pos = pos
+	return e
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s: %s", e.Pos, e.Kind, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+}
+
+// Traceback formats the error together with the calls that led to it,
+// outermost call first.
+func (e *RuntimeError) Traceback() string {
+	var sb strings.Builder
+	if len(e.Stack) > 0 {
+		sb.WriteString("Traceback (most recent call last):\n")
+		for i := len(e.Stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(&sb, "  %s in %s\n", e.Stack[i].Pos, e.Stack[i].Function)
+		}
+	}
+	sb.WriteString(e.Error())
+	return sb.String()
+}
+
+// ReturnSignal is propagated as an error by (return x) until it reaches the
+// enclosing function or program, which then evaluates to Value.
+type ReturnSignal struct {
+	Value Element
+}
+
+func (r *ReturnSignal) Error() string {
+	return "return outside of function"
+}
+
+// BreakSignal is propagated as an error by (break) until it reaches the
+// enclosing while.
+type BreakSignal struct {
+}
+
+func (b *BreakSignal) Error() string {
+	return "break outside of while"
+}
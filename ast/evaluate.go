@@ -1,256 +1,886 @@
 package ast
 
-import "fmt"
+import (
+	"math/rand"
+	"time"
 
+	"github.com/flychario/flylang/token"
+)
+
+// Context holds the variables of a scope. The variables resolved to lexical
+// addresses are kept in Slots, named by Names, and all others in Values.
 type Context struct {
-	Parent *Context
-	Values map[string]Element
+	Parent  *Context
+	Values  map[string]Element // nil in contexts with slots until a name is added
+	Names   []string
+	Slots   []Element // nil for names not bound yet
+	Runtime *Runtime  // shared by a global context and all contexts below it
+}
+
+// Runtime holds the settings of an interpreter.
+type Runtime struct {
+	// StrictBooleans makes and, or, when, unless and the tests of cond, if
+	// and while accept only booleans.
+	// Otherwise false and null count as false and any other value as true.
+	StrictBooleans bool
+
+	// Random gives the numbers of random and random-int.
+	Random *rand.Rand
 }
 
 func GetGlobalContext() *Context {
-	c := &Context{Parent: nil}
+	c := &Context{Parent: nil, Runtime: &Runtime{Random: rand.New(rand.NewSource(time.Now().UnixNano()))}}
 	c.Values = make(map[string]Element)
 	initBuiltins(c)
 	return c
 }
 
+// NewContext returns a context below parent, or a context with a runtime of
+// its own and no builtins if parent is nil.
 func NewContext(parent *Context) *Context {
-	c := &Context{Parent: parent}
+	if parent == nil {
+		return &Context{Values: make(map[string]Element), Runtime: &Runtime{Random: rand.New(rand.NewSource(time.Now().UnixNano()))}}
+	}
+	c := &Context{Parent: parent, Runtime: parent.Runtime}
 	c.Values = make(map[string]Element)
 	return c
 }
 
-func (c *Context) Add(name string, value Element) {
+// newFrame returns a context for the variables names, in slots of the same
+// index, or a context looking up variables by name if names is nil.
+func newFrame(parent *Context, names []string) *Context {
+	if names == nil {
+		return NewContext(parent)
+	}
+	return &Context{Parent: parent, Names: names, Slots: make([]Element, len(names)), Runtime: parent.Runtime}
+}
+
+func (c *Context) Add(name string, value Element) error {
+	value, err := contextValue(name, value)
+	if err != nil {
+		return err
+	}
+	c.define(name, value)
+	return nil
+}
+
+// bind adds the variable of slot i, which is named name unless the context
+// was made for other names.
+func (c *Context) bind(i int, name string, value Element) error {
+	value, err := contextValue(name, value)
+	if err != nil {
+		return err
+	}
+	if i < len(c.Names) && c.Names[i] == name {
+		c.Slots[i] = value
+	} else {
+		c.define(name, value)
+	}
+	return nil
+}
+
+// contextValue returns the value kept in a context for a variable set to
+// value.
+func contextValue(name string, value Element) (Element, error) {
 	if literal, ok := value.(Literal); ok {
-		c.Values[name] = literal
-		return
+		return literal, nil
 	} else if fun, ok := value.(Lambda); ok {
-		c.Values[name] = &fun
-		return
+		return &fun, nil
 	} else if fun, ok := value.(Prog); ok {
-		c.Values[name] = &fun
-		return
+		return &fun, nil
 	} else if atom, ok := value.(Builtin); ok {
-		c.Values[name] = &atom
-		return
+		return &atom, nil
 	} else if lst, ok := value.(List); ok {
-		c.Values[name] = lst
-		return
+		return lst, nil
+	} else if atom, ok := value.(Atom); ok {
+		return atom, nil
+	} else if _, ok := value.(Callable); ok {
+		return value, nil
 	}
 
-	CreateEvaluateError(fmt.Sprintf("Can't add value to context %s: %v", name, value.ElementType()))
+	return nil, NewRuntimeError(ErrorTypeMismatch, "Can't add value to context %s: %v", name, value.ElementType())
 }
 
-func (c *Context) Get(name string) Element {
-	if _, ok := c.Values[name]; ok {
-		return c.Values[name]
-	} else if c.Parent != nil {
-		return c.Parent.Get(name)
+// define binds name in c, in its slot if it has one.
+func (c *Context) define(name string, value Element) {
+	for i := len(c.Names) - 1; i >= 0; i-- {
+		if c.Names[i] == name {
+			c.Slots[i] = value
+			return
+		}
+	}
+	if c.Values == nil {
+		c.Values = make(map[string]Element)
 	}
+	c.Values[name] = value
+}
+
+// lookup returns the value of name bound in c itself.
+func (c *Context) lookup(name string) (Element, bool) {
+	if v, ok := c.Values[name]; ok {
+		return v, true
+	}
+	for i := len(c.Names) - 1; i >= 0; i-- {
+		if c.Names[i] == name && c.Slots[i] != nil {
+			return c.Slots[i], true
+		}
+	}
+	return nil, false
+}
 
+// frame returns the context holding the variable at the address of a, or
+// nil if a is not resolved or code evaluated outside of its scope, as by
+// eval, does not find it there.
+func (c *Context) frame(a Atom) *Context {
+	if !a.Resolved {
+		return nil
+	}
+	for i := 0; i < a.Depth && c != nil; i++ {
+		c = c.Parent
+	}
+	if c == nil || a.Index >= len(c.Names) || c.Names[a.Index] != a.Name || c.Slots[a.Index] == nil {
+		return nil
+	}
+	return c
+}
+
+// Set assigns value to the nearest binding of name visible from c, or
+// defines it in c when there is no such binding yet.
+func (c *Context) Set(name string, value Element) {
+	for ctx := c; ctx != nil; ctx = ctx.Parent {
+		if _, ok := ctx.Values[name]; ok {
+			ctx.Values[name] = value
+			return
+		}
+		for i := len(ctx.Names) - 1; i >= 0; i-- {
+			if ctx.Names[i] == name && ctx.Slots[i] != nil {
+				ctx.Slots[i] = value
+				return
+			}
+		}
+	}
+	c.define(name, value)
+}
+
+func (c *Context) Get(name string) Element {
+	for ctx := c; ctx != nil; ctx = ctx.Parent {
+		if v, ok := ctx.lookup(name); ok {
+			return v
+		}
+	}
 	return nil
 }
 
-func (a Atom) Eval(c *Context) Element {
+func (a Atom) Eval(c *Context) (Element, error) {
+	if f := c.frame(a); f != nil {
+		return f.Slots[a.Index], nil
+	}
 	val := c.Get(a.Name)
 	if val != nil {
-		return val
+		return val, nil
 	}
-	CreateEvaluateError("undefined variable: " + a.Name)
-	return nil
+	return nil, NewRuntimeError(ErrorUndefinedVariable, "%s", a.Name).at(a.Pos())
 }
 
-func (l LiteralInteger) Eval(c *Context) Element {
-	return l
+func (l LiteralInteger) Eval(c *Context) (Element, error) {
+	return l, nil
 }
 
-func (l LiteralReal) Eval(c *Context) Element {
-	return l
+func (l LiteralBigInteger) Eval(c *Context) (Element, error) {
+	return l, nil
 }
 
-func (l LiteralBoolean) Eval(c *Context) Element {
-	return l
+func (l LiteralRational) Eval(c *Context) (Element, error) {
+	return l, nil
 }
 
-func (l LiteralNull) Eval(c *Context) Element {
-	return l
+func (l LiteralReal) Eval(c *Context) (Element, error) {
+	return l, nil
 }
 
-func (l LiteralList) Eval(c *Context) Element {
-	return l
+func (l LiteralBoolean) Eval(c *Context) (Element, error) {
+	return l, nil
 }
 
-func (l ListElement) Eval(c *Context) Element {
-	evaluated := make([]Element, len(l.Elements))
-	for i, elem := range l.Elements {
-		evaluated[i] = elem.Eval(c)
-	}
+func (l LiteralNull) Eval(c *Context) (Element, error) {
+	return l, nil
+}
 
+func (l LiteralString) Eval(c *Context) (Element, error) {
+	return l, nil
+}
+
+func (l LiteralList) Eval(c *Context) (Element, error) {
+	return l, nil
+}
+
+func (l ListElement) Eval(c *Context) (Element, error) {
 	if len(l.Elements) == 0 {
-		return l
+		return l, nil
 	}
 
+	evaluated, err := evalAll(l.Elements, c)
+	if err != nil {
+		return nil, err
+	}
 	if fun, ok := evaluated[0].(Callable); ok {
-		return fun.Call(c, evaluated[1:])
+		res, err := fun.Call(c, evaluated[1:])
+		if err != nil {
+			return nil, l.traceCall(err, evaluated[0])
+		}
+		return res, nil
 	}
-	CreateEvaluateError("The first element of a list must be a function")
-	return nil
+	return nil, NewRuntimeError(ErrorTypeMismatch, "The first element of a list must be a function").at(l.Pos())
 }
 
-func (f Func) Eval(c *Context) Element {
-	c.Add(f.Atom.Name, Lambda{f.List, f.SubProg})
-	return f.Atom
-} //  `go run main.go file`
+func (m MapElement) Eval(c *Context) (Element, error) {
+	evaluated, err := evalAll(m.Elements, c)
+	if err != nil {
+		return nil, err
+	}
+	res, err := NewMap(Span{}, evaluated)
+	if err != nil {
+		return nil, err.(*RuntimeError).at(m.Pos())
+	}
+	return res, nil
+}
 
-func (q Quote) Eval(c *Context) Element {
-	return q.Element
+func (m Map) Eval(c *Context) (Element, error) {
+	return m, nil
 }
 
-func (s Setq) Eval(c *Context) Element {
-	c.Values[s.Atom.Name] = s.Element.Eval(c)
-	return s.Element.Eval(c)
+func (v VectorElement) Eval(c *Context) (Element, error) {
+	evaluated, err := evalAll(v.Elements, c)
+	if err != nil {
+		return nil, err
+	}
+	return Vector{Elements: evaluated}, nil
 }
 
-func (l Lambda) Eval(c *Context) Element {
-	return l
+func (v Vector) Eval(c *Context) (Element, error) {
+	return v, nil
 }
 
-func (l Prog) Eval(c *Context) Element {
-	return l
+// evalAll evaluates elements in order.
+func evalAll(elements []Element, c *Context) ([]Element, error) {
+	evaluated := make([]Element, len(elements))
+	for i, elem := range elements {
+		val, err := elem.Eval(c)
+		if err != nil {
+			return nil, err
+		}
+		evaluated[i] = val
+	}
+	return evaluated, nil
 }
 
-func (cond Cond) Eval(c *Context) Element {
-	body := cond.List.Eval(c)
-	if body.ElementType() != ElementTypeLiteral {
-		CreateEvaluateError("cond body evaluated to non-literal")
+// traceCall records the call of fun by l in the stack of a runtime error
+// and places errors raised without a position, like arity and type errors
+// of builtins, at l.
+func (l ListElement) traceCall(err error, fun Element) error {
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		return err
 	}
-	val := body.(Literal)
-	if val.Type() != LiteralTypeBoolean {
-		CreateEvaluateError("cond body evaluated to non-boolean")
+	if !rerr.Pos.IsValid() {
+		rerr.Pos = l.Pos()
 	}
-	if val.(LiteralBoolean).Value {
-		return cond.Element1.Eval(c)
-	} else if cond.Element2 != nil {
-		return cond.Element2.Eval(c)
+	name := "lambda"
+	if atom, ok := l.Elements[0].(Atom); ok {
+		name = atom.Name
+	} else if b, ok := fun.(*Builtin); ok {
+		name = b.Name
 	}
-	return LiteralNull{}
+	rerr.Stack = append(rerr.Stack, Frame{Function: name, Pos: l.Pos()})
+	return rerr
 }
 
-func (w While) Eval(c *Context) Element {
-	defer func() {
-		if r := recover(); r != nil { // Check if we get element from panic, otherwise it is error message
-			if elem, ok := r.(Element); ok {
-				if elem.ElementType() == ElementTypeBreak { // If it is not break it can be element from return
+// tailCall is a call to a lambda found in tail position. It is handed back
+// to the running Lambda.Call, which reuses its loop instead of growing the
+// Go stack.
+type tailCall struct {
+	fun  *Lambda
+	args []Element
+}
 
-				} else {
-					panic(r) // panic again with element for return
-				}
-			} else {
-				panic(r) // panic again with error message
+// evalTail evaluates an element in tail position. Calls to lambdas are not
+// performed but returned as a tailCall, cond, if and the other forms pass the
+// tail position on to the element deciding their value.
+func evalTail(e Element, c *Context) (Element, *tailCall, error) {
+	switch e := e.(type) {
+	case ListElement:
+		if len(e.Elements) == 0 {
+			return e, nil, nil
+		}
+		evaluated, err := evalAll(e.Elements, c)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch fun := evaluated[0].(type) {
+		case *Lambda:
+			return nil, &tailCall{fun, evaluated[1:]}, nil
+		case Lambda:
+			return nil, &tailCall{&fun, evaluated[1:]}, nil
+		case Callable:
+			res, err := fun.Call(c, evaluated[1:])
+			if err != nil {
+				return nil, nil, e.traceCall(err, evaluated[0])
 			}
+			return res, nil, nil
+		}
+		return nil, nil, NewRuntimeError(ErrorTypeMismatch, "The first element of a list must be a function").at(e.Pos())
+	case Cond:
+		clause, err := e.choose(c)
+		if err != nil || clause == nil {
+			return LiteralNull{}, nil, err
+		}
+		return evalBodyTail(clause.Body.Elements, c, LiteralBoolean{Value: true})
+	case Let:
+		inner, err := e.bind(c)
+		if err != nil {
+			return nil, nil, err
 		}
-	}()
+		return evalBodyTail(e.Body.Elements, inner, LiteralNull{})
+	case Logical:
+		res, decided, err := e.decide(c)
+		if err != nil || decided {
+			return res, nil, err
+		}
+		last := e.Elements[len(e.Elements)-1]
+		if c.Runtime.StrictBooleans {
+			res, _, err := evalTruth(last, c)
+			return res, nil, err
+		}
+		return evalTail(last, c)
+	case When:
+		ok, err := e.test(c)
+		if err != nil || !ok {
+			return LiteralNull{}, nil, err
+		}
+		return evalBodyTail(e.Body.Elements, c, LiteralNull{})
+	case If:
+		ok, err := evalCondition("if", e.Test, c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			return evalTail(e.Then, c)
+		} else if e.Else != nil {
+			return evalTail(e.Else, c)
+		}
+		return LiteralNull{}, nil, nil
+	}
+	res, err := e.Eval(c)
+	return res, nil, err
+}
+
+// evalBody evaluates the elements of a body and returns the value of the last
+// one, or empty if there are none.
+func evalBody(body []Element, c *Context, empty Element) (res Element, err error) {
+	res = empty
+	for _, elem := range body {
+		if res, err = elem.Eval(c); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// evalBodyTail is evalBody with the last element in tail position.
+func evalBodyTail(body []Element, c *Context, empty Element) (Element, *tailCall, error) {
+	if len(body) == 0 {
+		return empty, nil, nil
+	}
+	if _, err := evalBody(body[:len(body)-1], c, empty); err != nil {
+		return nil, nil, err
+	}
+	return evalTail(body[len(body)-1], c)
+}
 
-	for true {
-		body := w.Element1.Eval(c)
-		if body.ElementType() != ElementTypeLiteral {
-			CreateEvaluateError("cond body evaluated to non-literal")
+func (f Func) Eval(c *Context) (Element, error) {
+	if err := c.Add(f.Atom.Name, Lambda{Span: f.Span, List: f.List, SubProg: f.SubProg, Names: f.Names, Context: c}); err != nil {
+		return nil, err
+	}
+	return f.Atom, nil
+}
+
+func (q Quote) Eval(c *Context) (Element, error) {
+	return q.Element, nil
+}
+
+func (q Quasiquote) Eval(c *Context) (Element, error) {
+	return q.Fill(func(e Element) (Element, error) { return e.Eval(c) })
+}
+
+// Fill builds the value of q with the values of its unquoted elements given
+// by eval, which is called in the order of Unquoted.
+func (q Quasiquote) Fill(eval func(Element) (Element, error)) (Element, error) {
+	return quasiquote(q.Element, 1, eval)
+}
+
+// Unquoted returns the elements of q that are evaluated to build its value.
+func (q Quasiquote) Unquoted() []Element {
+	var unquoted []Element
+	quasiquote(q.Element, 1, func(e Element) (Element, error) {
+		unquoted = append(unquoted, e)
+		return ListElement{}, nil
+	})
+	return unquoted
+}
+
+// quasiquote builds the value of a quasiquote template at the given depth of
+// nested quasiquotes. Only unquotes at depth 1 are evaluated, deeper ones
+// are kept for the inner quasiquote.
+func quasiquote(e Element, depth int, eval func(Element) (Element, error)) (Element, error) {
+	switch e := e.(type) {
+	case Quasiquote:
+		inner, err := quasiquote(e.Element, depth+1, eval)
+		return Quasiquote{Span: e.Span, Element: inner}, err
+	case Unquote:
+		if depth == 1 {
+			return eval(e.Element)
 		}
-		val := body.(Literal)
-		if val.Type() != LiteralTypeBoolean {
-			CreateEvaluateError("cond body evaluated to non-boolean")
+		inner, err := quasiquote(e.Element, depth-1, eval)
+		return Unquote{Span: e.Span, Element: inner}, err
+	case UnquoteSplicing:
+		if depth == 1 {
+			return nil, NewRuntimeError(ErrorTypeMismatch, "unquote-splicing outside of a list").at(e.Pos())
 		}
-		if val.(LiteralBoolean).Value {
-			for _, elem := range w.Element2.Elements {
-				elem.Eval(c)
-			}
-		} else {
-			break
+		inner, err := quasiquote(e.Element, depth-1, eval)
+		return UnquoteSplicing{Span: e.Span, Element: inner}, err
+	case ListElement:
+		elements, err := quasiquoteElements(e.Elements, depth, eval)
+		if err != nil {
+			return nil, err
+		}
+		return ListElement{Span: e.Span, Elements: elements}, nil
+	case MapElement:
+		elements, err := quasiquoteElements(e.Elements, depth, eval)
+		if err != nil {
+			return nil, err
+		} else if depth > 1 {
+			return MapElement{Span: e.Span, Elements: elements}, nil
+		}
+		res, err := NewMap(e.Span, elements)
+		if err != nil {
+			return nil, err.(*RuntimeError).at(e.Pos())
+		}
+		return res, nil
+	case VectorElement:
+		elements, err := quasiquoteElements(e.Elements, depth, eval)
+		if err != nil {
+			return nil, err
+		} else if depth > 1 {
+			return VectorElement{Span: e.Span, Elements: elements}, nil
 		}
+		return Vector{Span: e.Span, Elements: elements}, nil
 	}
-	return LiteralNull{}
+	return e, nil
 }
 
-func (p Program) Eval(c *Context) (res Element) {
-	defer func() {
-		if r := recover(); r != nil { // Check if we get element from panic, otherwise it is error message
-			if elem, ok := r.(Element); ok {
-				res = elem
-			} else {
-				panic(r) // panic again with error message
+// quasiquoteElements builds the elements of a list, map or vector template,
+// splicing in the lists of the ones unquoted by ,@.
+func quasiquoteElements(template []Element, depth int, eval func(Element) (Element, error)) ([]Element, error) {
+	var elements []Element
+	for _, elem := range template {
+		if s, ok := elem.(UnquoteSplicing); ok && depth == 1 {
+			val, err := eval(s.Element)
+			if err != nil {
+				return nil, err
 			}
+			lst, ok := val.(ListElement)
+			if !ok {
+				return nil, NewRuntimeError(ErrorTypeMismatch, "Can't splice %v, it is not a list", val).at(s.Pos())
+			}
+			elements = append(elements, lst.Elements...)
+			continue
+		}
+		val, err := quasiquote(elem, depth, eval)
+		if err != nil {
+			return nil, err
 		}
-	}()
+		elements = append(elements, val)
+	}
+	return elements, nil
+}
 
-	for _, e := range p.Elements {
-		res = e.Eval(c)
+// Unquotes are evaluated by the quasiquote around them.
+
+func (u Unquote) Eval(c *Context) (Element, error) {
+	return nil, NewRuntimeError(ErrorRuntime, "unquote outside of quasiquote").at(u.Pos())
+}
+
+func (u UnquoteSplicing) Eval(c *Context) (Element, error) {
+	return nil, NewRuntimeError(ErrorRuntime, "unquote-splicing outside of quasiquote").at(u.Pos())
+}
+
+func (s Setq) Eval(c *Context) (Element, error) {
+	val, err := s.Element.Eval(c)
+	if err != nil {
+		return nil, err
 	}
+	if f := c.frame(s.Atom); f != nil {
+		f.Slots[s.Atom.Index] = val
+	} else {
+		c.Set(s.Atom.Name, val)
+	}
+	return val, nil
+}
+
+func (l Lambda) Eval(c *Context) (Element, error) {
+	// Capture the defining context so the body sees it on every call
+	return Lambda{Span: l.Span, List: l.List, SubProg: l.SubProg, Names: l.Names, Context: c}, nil
+}
+
+func (l Prog) Eval(c *Context) (Element, error) {
+	return l, nil
+}
+
+func (cond Cond) Eval(c *Context) (Element, error) {
+	clause, err := cond.choose(c)
+	if err != nil || clause == nil {
+		return LiteralNull{}, err
+	}
+	// A clause without body gives the value of its test
+	return evalBody(clause.Body.Elements, c, LiteralBoolean{Value: true})
+}
 
-	return res
+// choose returns the first clause of cond whose test is true, or nil if
+// there is none.
+func (cond Cond) choose(c *Context) (*Clause, error) {
+	for i, clause := range cond.Clauses {
+		if clause.Test == nil {
+			return &cond.Clauses[i], nil
+		}
+		ok, err := evalCondition("cond", clause.Test, c)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &cond.Clauses[i], nil
+		}
+	}
+	return nil, nil
 }
 
-func (l Lambda) Call(c *Context, args []Element) (res Element) {
-	if len(l.List.GetElements()) > len(args) {
-		CreateEvaluateError("not enough arguments in lambda call")
-	} else if len(l.List.GetElements()) < len(args) {
-		CreateEvaluateError("too many arguments in lambda call")
+func (i If) Eval(c *Context) (Element, error) {
+	ok, err := evalCondition("if", i.Test, c)
+	if err != nil {
+		return nil, err
 	}
+	if ok {
+		return i.Then.Eval(c)
+	} else if i.Else != nil {
+		return i.Else.Eval(c)
+	}
+	return LiteralNull{}, nil
+}
 
-	newContext := NewContext(c)
-	for i, arg := range args {
-		newContext.Add(l.List.GetElements()[i].(Atom).Name, arg)
+func (l Let) Eval(c *Context) (Element, error) {
+	inner, err := l.bind(c)
+	if err != nil {
+		return nil, err
 	}
+	return evalBody(l.Body.Elements, inner, LiteralNull{})
+}
 
-	defer func() {
-		if r := recover(); r != nil { // Check if we get element from panic, otherwise it is error message
-			if elem, ok := r.(Element); ok {
-				res = elem
-			} else {
-				panic(r) // panic again with error message
-			}
+// bind creates the context of the body of l holding its bindings.
+func (l Let) bind(c *Context) (*Context, error) {
+	inner := newFrame(c, l.Names)
+	scope := inner
+	switch l.Kind {
+	case token.LET:
+		scope = c
+	case token.LETREC:
+		for i, b := range l.Bindings {
+			inner.bind(i, b.Name.Name, LiteralNull{})
 		}
-	}()
+	}
 
-	for _, e := range l.SubProg.Elements {
-		res = e.Eval(newContext)
+	for i, b := range l.Bindings {
+		value, err := b.Value.Eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		if err := inner.bind(i, b.Name.Name, value); err != nil {
+			return nil, err
+		}
 	}
-	return res
+	return inner, nil
 }
 
-func (l Prog) Call(c *Context, args []Element) (res Element) {
-	if len(l.List.GetElements()) > len(args) {
-		CreateEvaluateError("not enough arguments in program call")
-	} else if len(l.List.GetElements()) < len(args) {
-		CreateEvaluateError("too many arguments in program call")
+func (l Logical) Eval(c *Context) (Element, error) {
+	res, decided, err := l.decide(c)
+	if err != nil || decided {
+		return res, err
 	}
+	res, _, err = evalTruth(l.Elements[len(l.Elements)-1], c)
+	return res, err
+}
 
-	newContext := NewContext(c)
-	for i, arg := range args {
-		newContext.Add(l.List.GetElements()[i].(Atom).Name, arg)
+// decide evaluates the operands of l but the last one until one of them
+// decides the result and returns its value. Without operands the result is
+// decided too.
+func (l Logical) decide(c *Context) (Element, bool, error) {
+	and := l.Kind == token.AND
+	if len(l.Elements) == 0 {
+		return LiteralBoolean{Value: and}, true, nil
+	}
+	for _, elem := range l.Elements[:len(l.Elements)-1] {
+		res, ok, err := evalTruth(elem, c)
+		if err != nil {
+			return nil, true, err
+		}
+		if ok != and {
+			return res, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (w When) Eval(c *Context) (Element, error) {
+	ok, err := w.test(c)
+	if err != nil || !ok {
+		return LiteralNull{}, err
 	}
+	return evalBody(w.Body.Elements, c, LiteralNull{})
+}
+
+// test reports whether the body of w is to be evaluated.
+func (w When) test(c *Context) (bool, error) {
+	_, ok, err := evalTruth(w.Test, c)
+	return ok != (w.Kind == token.UNLESS), err
+}
+
+// evalTruth evaluates an operand of and, or, when or unless and reports
+// whether it counts as true.
+func evalTruth(e Element, c *Context) (Element, bool, error) {
+	res, err := e.Eval(c)
+	if err != nil {
+		return nil, false, err
+	}
+	switch v := res.(type) {
+	case LiteralBoolean:
+		return v, v.Value, nil
+	case LiteralNull:
+		if !c.Runtime.StrictBooleans {
+			return v, false, nil
+		}
+	default:
+		if !c.Runtime.StrictBooleans {
+			return v, true, nil
+		}
+	}
+	return nil, false, NewRuntimeError(ErrorTypeMismatch, "Can't use logical operator on %s", res).at(e.Pos())
+}
+
+// evalCondition evaluates the test of form, a cond, if or while, and reports
+// whether it counts as true.
+func evalCondition(form string, e Element, c *Context) (bool, error) {
+	res, err := e.Eval(c)
+	if err != nil {
+		return false, err
+	}
+	switch v := res.(type) {
+	case LiteralBoolean:
+		return v.Value, nil
+	case LiteralNull:
+		if !c.Runtime.StrictBooleans {
+			return false, nil
+		}
+	default:
+		if !c.Runtime.StrictBooleans {
+			return true, nil
+		}
+	}
+	return false, NewRuntimeError(ErrorTypeMismatch, "%s test evaluated to non-boolean %s", form, res).at(e.Pos())
+}
+
+// Macro definitions are taken out of a program when its macros are expanded,
+// so evaluating one means the program was not expanded.
+
+func (d DefMacro) Eval(c *Context) (Element, error) {
+	return nil, NewRuntimeError(ErrorSyntax, "macro %s defined without macro expansion", d.Atom.Name).at(d.Pos())
+}
 
-	defer func() {
-		if r := recover(); r != nil { // Check if we get element from panic, otherwise it is error message
-			if elem, ok := r.(Element); ok {
-				res = elem
-			} else {
-				panic(r) // panic again with error message
+func (d DefineSyntax) Eval(c *Context) (Element, error) {
+	return nil, NewRuntimeError(ErrorSyntax, "macro %s defined without macro expansion", d.Atom.Name).at(d.Pos())
+}
+
+func (w While) Eval(c *Context) (Element, error) {
+	for {
+		ok, err := evalCondition("while", w.Element1, c)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		for _, elem := range w.Element2.Elements {
+			if _, err := elem.Eval(c); err != nil {
+				if _, ok := err.(*BreakSignal); ok {
+					return LiteralNull{}, nil
+				}
+				return nil, err // errors and returns go further up
 			}
 		}
-	}()
+	}
+	return LiteralNull{}, nil
+}
+
+func (p Program) Eval(c *Context) (Element, error) {
+	return p.EvalEach(c, nil)
+}
+
+// EvalEach evaluates p like Eval, passing each element through prepare, if
+// given, right before evaluating it. Macros are expanded this way, so a macro
+// can use the functions defined before its first call.
+func (p Program) EvalEach(c *Context, prepare func(Element) (Element, error)) (res Element, err error) {
+	for _, e := range p.Elements {
+		if prepare != nil {
+			if e, err = prepare(e); err != nil {
+				return nil, err
+			}
+		}
+		res, err = e.Eval(c)
+		if err != nil {
+			return returnValue(err)
+		}
+	}
+
+	return res, nil
+}
+
+func (l Lambda) Call(c *Context, args []Element) (Element, error) {
+	// Calls in tail position replace fun and args and go round the loop
+	// again, so tail recursion runs in constant Go stack space
+	fun := &l
+	for {
+		newContext, err := fun.bind(c, args)
+		if err != nil {
+			return nil, err
+		}
+		body := fun.SubProg.Elements
+		if len(body) == 0 {
+			return LiteralNull{}, nil
+		}
+		for _, e := range body[:len(body)-1] {
+			if _, err := e.Eval(newContext); err != nil {
+				return returnValue(err)
+			}
+		}
+
+		res, call, err := evalTail(body[len(body)-1], newContext)
+		if err != nil {
+			return returnValue(err)
+		}
+		if call == nil {
+			return res, nil
+		}
+		fun, args, c = call.fun, call.args, newContext
+	}
+}
+
+// bind creates the context for a call of the lambda with its parameters
+// bound to args.
+func (l *Lambda) bind(c *Context, args []Element) (*Context, error) {
+	parent := l.Context
+	if parent == nil {
+		parent = c
+	}
+	return bindArgs(newFrame(parent, l.Names), l.List, args, "lambda")
+}
+
+func (l Prog) Call(c *Context, args []Element) (res Element, err error) {
+	newContext, err := bindArgs(NewContext(c), l.List, args, "program")
+	if err != nil {
+		return nil, err
+	}
 
 	for _, e := range l.SubProg.Elements {
-		res = e.Eval(newContext)
+		res, err = e.Eval(newContext)
+		if err != nil {
+			return returnValue(err)
+		}
+	}
+	return res, nil
+}
+
+// bindArgs binds the parameters of a lambda or program to args in c, each
+// in the slot of its index if c has slots.
+// Parameters after &optional may be missing from args and are bound to
+// null, or to the value of a default given as (name default). A parameter
+// after &rest is bound to the list of the remaining arguments.
+func bindArgs(c *Context, params List, args []Element, kind string) (*Context, error) {
+	mode := ""
+	i, slot := 0, 0
+	for _, param := range params.GetElements() {
+		if atom, ok := param.(Atom); ok && (atom.Name == "&optional" || atom.Name == "&rest") {
+			mode = atom.Name
+			continue
+		}
 
+		name, def := parseParam(param)
+
+		var value Element
+		switch {
+		case mode == "&rest":
+			value = ListElement{Elements: append([]Element{}, args[i:]...)}
+			i = len(args)
+		case i < len(args):
+			value = args[i]
+			i++
+		case mode == "":
+			return nil, NewRuntimeError(ErrorArityMismatch, "not enough arguments in %s call", kind)
+		case def != nil:
+			var err error
+			if value, err = def.Eval(c); err != nil {
+				return nil, err
+			}
+		default:
+			value = LiteralNull{}
+		}
+		if err := c.bind(slot, name, value); err != nil {
+			return nil, err
+		}
+		slot++
+	}
+
+	if i < len(args) {
+		return nil, NewRuntimeError(ErrorArityMismatch, "too many arguments in %s call", kind)
+	}
+	return c, nil
+}
+
+// parseParam returns the name and default value of a parameter, which the
+// parser checked is a name or (name default).
+func parseParam(param Element) (string, Element) {
+	if p, ok := param.(ListElement); ok {
+		return p.Elements[0].(Atom).Name, p.Elements[1]
+	}
+	return param.(Atom).Name, nil
+}
+
+// returnValue turns a return signal that reached a function boundary into
+// the function's result. Break can not leave a function.
+func returnValue(err error) (Element, error) {
+	switch sig := err.(type) {
+	case *ReturnSignal:
+		return sig.Value, nil
+	case *BreakSignal:
+		return nil, NewRuntimeError(ErrorRuntime, "break outside of while")
 	}
-	return res
+	return nil, err
 }
 
-func (r Return) Eval(c *Context) Element {
-	var elem = r.Element.Eval(c)
-	panic(elem)
+func (r Return) Eval(c *Context) (Element, error) {
+	elem, err := r.Element.Eval(c)
+	if err != nil {
+		return nil, err
+	}
+	return nil, &ReturnSignal{elem}
 }
 
-func (b Break) Eval(c *Context) Element {
-	panic(b)
+func (b Break) Eval(c *Context) (Element, error) {
+	return nil, &BreakSignal{}
 }
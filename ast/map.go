@@ -0,0 +1,283 @@
+package ast
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"sort"
+)
+
+// Maps are hash array mapped tries: each node branches on the next 5 bits of
+// the hash of a key and holds only the branches in use. Put and del copy the
+// nodes on the path to a key, O(log n) of them, and share the rest with the
+// map they were given, so building a map key by key takes O(n log n).
+
+// mapKey identifies a key of a map. Keys are equal when they print the same
+// and are both atoms or both literals, as integers and reals print
+// differently and exact numbers have a single form.
+type mapKey struct {
+	atom bool
+	text string
+}
+
+// keyOf returns the map key of e, or false if e can't be a key.
+func keyOf(e Element) (mapKey, bool) {
+	switch e := e.(type) {
+	case Atom:
+		return mapKey{atom: true, text: e.Name}, true
+	case LiteralList:
+		return mapKey{}, false
+	case Literal:
+		return mapKey{text: Print(e)}, true
+	}
+	return mapKey{}, false
+}
+
+func keyError(key Element) error {
+	return NewRuntimeError(ErrorTypeMismatch, "Can't use %v as a map key", key)
+}
+
+var mapSeed = maphash.MakeSeed()
+
+func (k mapKey) hash() uint64 {
+	h := maphash.String(mapSeed, k.text)
+	if k.atom {
+		h = ^h
+	}
+	return h
+}
+
+// mapEntry is a key of a map with its value. Keys added earlier have lower
+// orders.
+type mapEntry struct {
+	hash  uint64
+	key   mapKey
+	elem  Element // the key as given
+	value Element
+	order int
+}
+
+// mapNode is a node of a trie. Bit i of bitmap is set if the node has the
+// slot for the 5 bits of hash i, slots holding the set ones in order.
+type mapNode struct {
+	bitmap uint32
+	slots  []mapSlot
+}
+
+// mapSlot is a subtrie, or if node is nil, the entries of the keys with the
+// same hash.
+type mapSlot struct {
+	node    *mapNode
+	entries []mapEntry
+}
+
+// index returns the bit of hash in n at shift and the index of its slot.
+func (n *mapNode) index(hash uint64, shift uint) (uint32, int) {
+	bit := uint32(1) << ((hash >> shift) & 31)
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (n *mapNode) get(k mapKey, hash uint64) (*mapEntry, bool) {
+	for shift := uint(0); n != nil; shift += 5 {
+		bit, i := n.index(hash, shift)
+		if n.bitmap&bit == 0 {
+			return nil, false
+		}
+		if s := n.slots[i]; s.node == nil {
+			for j := range s.entries {
+				if s.entries[j].key == k {
+					return &s.entries[j], true
+				}
+			}
+			return nil, false
+		}
+		n = n.slots[i].node
+	}
+	return nil, false
+}
+
+// put returns n with e added, and whether e is a new key. The entry of a key
+// that is already there keeps its order.
+func (n *mapNode) put(e mapEntry, shift uint) (*mapNode, bool) {
+	if n == nil {
+		n = &mapNode{}
+	}
+	bit, i := n.index(e.hash, shift)
+	res := &mapNode{bitmap: n.bitmap | bit}
+	if n.bitmap&bit == 0 {
+		res.slots = make([]mapSlot, len(n.slots)+1)
+		copy(res.slots, n.slots[:i])
+		res.slots[i] = mapSlot{entries: []mapEntry{e}}
+		copy(res.slots[i+1:], n.slots[i:])
+		return res, true
+	}
+	res.slots = append([]mapSlot(nil), n.slots...)
+	s := n.slots[i]
+	added := false
+	switch {
+	case s.node != nil:
+		res.slots[i].node, added = s.node.put(e, shift+5)
+	case s.entries[0].hash == e.hash:
+		entries := append([]mapEntry(nil), s.entries...)
+		added = true
+		for j := range entries {
+			if entries[j].key == e.key {
+				e.order = entries[j].order
+				entries[j], added = e, false
+				break
+			}
+		}
+		if added {
+			entries = append(entries, e)
+		}
+		res.slots[i].entries = entries
+	default:
+		// Move the entries one level down, where the hashes may differ
+		bit := uint32(1) << ((s.entries[0].hash >> (shift + 5)) & 31)
+		child := &mapNode{bitmap: bit, slots: []mapSlot{{entries: s.entries}}}
+		res.slots[i] = mapSlot{}
+		res.slots[i].node, added = child.put(e, shift+5)
+	}
+	return res, added
+}
+
+// del returns n without the key k, nil if nothing is left, and whether k
+// was there.
+func (n *mapNode) del(k mapKey, hash uint64, shift uint) (*mapNode, bool) {
+	bit, i := n.index(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	s := n.slots[i]
+	var slot mapSlot
+	if s.node != nil {
+		child, ok := s.node.del(k, hash, shift+5)
+		if !ok {
+			return n, false
+		}
+		slot.node = child
+	} else {
+		j := 0
+		for j < len(s.entries) && s.entries[j].key != k {
+			j++
+		}
+		if j == len(s.entries) {
+			return n, false
+		}
+		slot.entries = append(append([]mapEntry(nil), s.entries[:j]...), s.entries[j+1:]...)
+	}
+
+	if slot.node == nil && len(slot.entries) == 0 {
+		if n.bitmap == bit {
+			return nil, true
+		}
+		res := &mapNode{bitmap: n.bitmap &^ bit, slots: make([]mapSlot, 0, len(n.slots)-1)}
+		res.slots = append(append(res.slots, n.slots[:i]...), n.slots[i+1:]...)
+		return res, true
+	}
+	res := &mapNode{bitmap: n.bitmap, slots: append([]mapSlot(nil), n.slots...)}
+	res.slots[i] = slot
+	return res, true
+}
+
+// each calls f for the entries of n.
+func (n *mapNode) each(f func(*mapEntry)) {
+	if n == nil {
+		return
+	}
+	for _, s := range n.slots {
+		if s.node != nil {
+			s.node.each(f)
+		}
+		for j := range s.entries {
+			f(&s.entries[j])
+		}
+	}
+}
+
+// NewMap returns the map of elements, which are keys each followed by its
+// value. A key given twice keeps the last value.
+func NewMap(span Span, elements []Element) (Map, error) {
+	if len(elements)%2 != 0 {
+		return Map{}, NewRuntimeError(ErrorArityMismatch, "Map literal has no value for key %v", elements[len(elements)-1])
+	}
+	m := Map{Span: span}
+	for i := 0; i < len(elements); i += 2 {
+		var err error
+		if m, err = m.Put(elements[i], elements[i+1]); err != nil {
+			return Map{}, err
+		}
+	}
+	m.Span = span
+	return m, nil
+}
+
+// Len returns the number of keys of m.
+func (m Map) Len() int { return m.size }
+
+// Keys returns the keys of m in the order they were added.
+func (m Map) Keys() []Element {
+	entries := m.entries()
+	keys := make([]Element, len(entries))
+	for i, e := range entries {
+		keys[i] = e.elem
+	}
+	return keys
+}
+
+// entries returns the entries of m in the order their keys were added.
+func (m Map) entries() []*mapEntry {
+	entries := make([]*mapEntry, 0, m.size)
+	m.root.each(func(e *mapEntry) { entries = append(entries, e) })
+	sort.Slice(entries, func(i, j int) bool { return entries[i].order < entries[j].order })
+	return entries
+}
+
+// Get returns the value of key in m.
+func (m Map) Get(key Element) (Element, bool) {
+	k, ok := keyOf(key)
+	if !ok {
+		return nil, false
+	}
+	e, ok := m.root.get(k, k.hash())
+	if !ok {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Put returns m with key set to value.
+func (m Map) Put(key, value Element) (Map, error) {
+	k, ok := keyOf(key)
+	if !ok {
+		return Map{}, keyError(key)
+	}
+	root, added := m.root.put(mapEntry{hash: k.hash(), key: k, elem: key, value: value, order: m.next}, 0)
+	res := Map{root: root, size: m.size, next: m.next}
+	if added {
+		res.size++
+		res.next++
+	}
+	return res, nil
+}
+
+// Del returns m without key.
+func (m Map) Del(key Element) Map {
+	k, ok := keyOf(key)
+	if !ok || m.root == nil {
+		return m
+	}
+	root, ok := m.root.del(k, k.hash(), 0)
+	if !ok {
+		return m
+	}
+	return Map{root: root, size: m.size - 1, next: m.next}
+}
+
+// Merge returns m with the keys of other set to their values in other.
+func (m Map) Merge(other Map) Map {
+	res := Map{root: m.root, size: m.size, next: m.next}
+	for _, e := range other.entries() {
+		res, _ = res.Put(e.elem, e.value)
+	}
+	return res
+}
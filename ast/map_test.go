@@ -0,0 +1,76 @@
+package ast
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Maps built by put and del hold the keys left, in the order they were
+// added, and leave the maps they were made from unchanged
+func TestMapPutDel(t *testing.T) {
+	var m Map
+	var versions []Map
+	for i := 0; i < 2000; i++ {
+		var err error
+		if m, err = m.Put(LiteralInteger{Value: int64(i)}, LiteralString{Value: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, m)
+	}
+	for i := 0; i < 2000; i += 2 {
+		m = m.Del(LiteralInteger{Value: int64(i)})
+	}
+	m, _ = m.Put(LiteralInteger{Value: 1}, LiteralString{Value: "one"})
+
+	if m.Len() != 1000 {
+		t.Errorf("expected 1000 keys, got %d", m.Len())
+	}
+	for i, key := range m.Keys() {
+		if want := int64(2*i + 1); !Equal(key, LiteralInteger{Value: want}) {
+			t.Fatalf("expected key %d at %d, got %v", want, i, key)
+		}
+	}
+	if v, ok := m.Get(LiteralInteger{Value: 1}); !ok || !Equal(v, LiteralString{Value: "one"}) {
+		t.Errorf("expected one, got %v", v)
+	}
+	if _, ok := m.Get(LiteralInteger{Value: 2}); ok {
+		t.Errorf("expected 2 to be deleted")
+	}
+	for i, v := range versions {
+		if v.Len() != i+1 {
+			t.Fatalf("expected %d keys in version %d, got %d", i+1, i, v.Len())
+		}
+		if value, ok := v.Get(LiteralInteger{Value: int64(i)}); !ok || !Equal(value, LiteralString{Value: strconv.Itoa(i)}) {
+			t.Fatalf("expected %d in version %d, got %v", i, i, value)
+		}
+	}
+}
+
+// Keys whose hashes are the same share a slot
+func TestMapCollisions(t *testing.T) {
+	var root *mapNode
+	for i, text := range []string{"a", "b", "c"} {
+		root, _ = root.put(mapEntry{hash: 42, key: mapKey{text: text}, value: LiteralInteger{Value: int64(i)}, order: i}, 0)
+	}
+	root, _ = root.put(mapEntry{hash: 42 | 1<<40, key: mapKey{text: "d"}, order: 3}, 0)
+	root, added := root.put(mapEntry{hash: 42, key: mapKey{text: "b"}, value: LiteralInteger{Value: 7}}, 0)
+	if added {
+		t.Errorf("expected b to be replaced")
+	}
+	if e, ok := root.get(mapKey{text: "b"}, 42); !ok || e.order != 1 || !Equal(e.value, LiteralInteger{Value: 7}) {
+		t.Errorf("expected b set to 7 at 1, got %v", e)
+	}
+	root, _ = root.del(mapKey{text: "a"}, 42, 0)
+	root, _ = root.del(mapKey{text: "d"}, 42|1<<40, 0)
+	var texts string
+	root.each(func(e *mapEntry) { texts += e.key.text })
+	if texts != "bc" {
+		t.Errorf("expected keys b and c, got %q", texts)
+	}
+	if root, _ = root.del(mapKey{text: "b"}, 42, 0); root == nil {
+		t.Fatal("expected c to be left")
+	}
+	if root, _ = root.del(mapKey{text: "c"}, 42, 0); root != nil {
+		t.Errorf("expected an empty trie, got %v", root)
+	}
+}
@@ -0,0 +1,305 @@
+package ast
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Numbers are integers, rationals and reals, each kind holding the ones
+// before it. Integers are LiteralInteger while they fit in an int64 and
+// LiteralBigInteger beyond. Integers and rationals are exact, reals are not.
+// Arithmetic on two numbers is carried out on the kind of the more general
+// one, so an integer and a real give a real, and integer results that
+// overflow an int64 are computed again on big integers. Exact results are
+// given as the least general kind that holds them.
+
+// maxBits limits the size of the integers made by expt and shift, so a
+// program asking for a huge one fails instead of running out of memory.
+const maxBits = 1 << 24
+
+// numberKind orders the kinds of numbers, each holding the values of the
+// kinds before it.
+type numberKind int
+
+const (
+	notNumber numberKind = iota
+	kindInteger
+	kindBigInteger
+	kindRational
+	kindReal
+)
+
+func kindOf(l Literal) numberKind {
+	switch l.(type) {
+	case LiteralInteger:
+		return kindInteger
+	case LiteralBigInteger:
+		return kindBigInteger
+	case LiteralRational:
+		return kindRational
+	case LiteralReal:
+		return kindReal
+	}
+	return notNumber
+}
+
+// commonKind returns the kind a and b are converted to for an operation on
+// both, or notNumber if one of them is not a number.
+func commonKind(a, b Literal) numberKind {
+	ka, kb := kindOf(a), kindOf(b)
+	if ka == notNumber || kb == notNumber {
+		return notNumber
+	} else if ka > kb {
+		return ka
+	}
+	return kb
+}
+
+func toBig(l Literal) *big.Int {
+	switch n := l.(type) {
+	case LiteralInteger:
+		return big.NewInt(n.Value)
+	case LiteralBigInteger:
+		return n.Value
+	}
+	panic("not an integer")
+}
+
+func toRat(l Literal) *big.Rat {
+	switch n := l.(type) {
+	case LiteralInteger:
+		return new(big.Rat).SetInt64(n.Value)
+	case LiteralBigInteger:
+		return new(big.Rat).SetInt(n.Value)
+	case LiteralRational:
+		return n.Value
+	}
+	panic("not an exact number")
+}
+
+// ToFloat returns the number e as a float64, or false if e is not a number.
+// Exact numbers are rounded to the nearest float64.
+func ToFloat(e Element) (float64, bool) {
+	if l, ok := e.(Literal); ok && kindOf(l) != notNumber {
+		return toFloat(l), true
+	}
+	return 0, false
+}
+
+func toFloat(l Literal) float64 {
+	switch n := l.(type) {
+	case LiteralInteger:
+		return float64(n.Value)
+	case LiteralBigInteger:
+		f, _ := new(big.Float).SetInt(n.Value).Float64()
+		return f
+	case LiteralRational:
+		f, _ := n.Value.Float64()
+		return f
+	case LiteralReal:
+		return n.Value
+	}
+	panic("not a number")
+}
+
+// integer returns x as a LiteralInteger if it fits in one.
+func integer(x *big.Int) Literal {
+	if x.IsInt64() {
+		return LiteralInteger{Value: x.Int64()}
+	}
+	return LiteralBigInteger{Value: x}
+}
+
+// NewRational returns the number r, which is an integer if its denominator
+// is 1.
+func NewRational(span Span, r *big.Rat) Literal {
+	if !r.IsInt() {
+		return LiteralRational{Span: span, Value: r}
+	} else if n := r.Num(); n.IsInt64() {
+		return LiteralInteger{Span: span, Value: n.Int64()}
+	}
+	return LiteralBigInteger{Span: span, Value: r.Num()}
+}
+
+// ParseReal returns the value of a real literal, which is a decimal number
+// or one of +inf.0, -inf.0 and +nan.0.
+func ParseReal(lit string) (float64, error) {
+	switch lit {
+	case "+inf.0":
+		return math.Inf(1), nil
+	case "-inf.0":
+		return math.Inf(-1), nil
+	case "+nan.0", "-nan.0":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(lit, 64)
+}
+
+// exact returns n as an exact number. Reals are exactly converted to the
+// fraction of powers of 2 they store, so 0.5 gives 1/2.
+func exact(n Literal) (Literal, error) {
+	r, ok := n.(LiteralReal)
+	if !ok {
+		return n, nil
+	}
+	if math.IsNaN(r.Value) || math.IsInf(r.Value, 0) {
+		return nil, NewRuntimeError(ErrorTypeMismatch, "Can't make %v exact", r)
+	}
+	return NewRational(Span{}, new(big.Rat).SetFloat64(r.Value)), nil
+}
+
+// evalNumber evaluates the argument of a builtin, which must be a number.
+func evalNumber(c *Context, arg Element, name string) (Literal, error) {
+	ae, err := arg.Eval(c)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := ae.(Literal); ok && kindOf(n) != notNumber {
+		return n, nil
+	}
+	return nil, NewRuntimeError(ErrorTypeMismatch, "%s expects a number, got %v", name, ae)
+}
+
+// isExactZero reports whether l is the integer 0.
+func isExactZero(l Literal) bool {
+	switch n := l.(type) {
+	case LiteralInteger:
+		return n.Value == 0
+	case LiteralBigInteger:
+		return n.Value.Sign() == 0
+	}
+	return false
+}
+
+// arithmeticOp is an operation on each kind of numbers. The operation on
+// int64s reports whether its result is right, otherwise the operation on big
+// integers is used, and if there is none, the one on rationals.
+type arithmeticOp struct {
+	verb     string
+	integer  func(a, b int64) (int64, bool)
+	big      func(z, a, b *big.Int) *big.Int
+	rational func(z, a, b *big.Rat) *big.Rat
+	real     func(a, b float64) float64
+}
+
+func (op arithmeticOp) apply(a, b Literal) (Element, error) {
+	switch commonKind(a, b) {
+	case kindInteger:
+		if res, ok := op.integer(a.(LiteralInteger).Value, b.(LiteralInteger).Value); ok {
+			return LiteralInteger{Value: res}, nil
+		}
+		fallthrough
+	case kindBigInteger:
+		if op.big != nil {
+			return integer(op.big(new(big.Int), toBig(a), toBig(b))), nil
+		}
+		fallthrough
+	case kindRational:
+		return NewRational(Span{}, op.rational(new(big.Rat), toRat(a), toRat(b))), nil
+	case kindReal:
+		return LiteralReal{Value: op.real(toFloat(a), toFloat(b))}, nil
+	}
+	return nil, NewRuntimeError(ErrorTypeMismatch, "%s %v and %v", op.verb, a, b)
+}
+
+var (
+	addition = arithmeticOp{
+		verb: "Can't add",
+		integer: func(a, b int64) (int64, bool) {
+			s := a + b
+			return s, (s > a) == (b > 0)
+		},
+		big:      (*big.Int).Add,
+		rational: (*big.Rat).Add,
+		real:     func(a, b float64) float64 { return a + b },
+	}
+	subtraction = arithmeticOp{
+		verb: "Can't subtract",
+		integer: func(a, b int64) (int64, bool) {
+			d := a - b
+			return d, (d < a) == (b > 0)
+		},
+		big:      (*big.Int).Sub,
+		rational: (*big.Rat).Sub,
+		real:     func(a, b float64) float64 { return a - b },
+	}
+	multiplication = arithmeticOp{
+		verb: "Can't multiply",
+		integer: func(a, b int64) (int64, bool) {
+			if a == 0 || b == 0 {
+				return 0, true
+			}
+			p := a * b
+			return p, p/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+		},
+		big:      (*big.Int).Mul,
+		rational: (*big.Rat).Mul,
+		real:     func(a, b float64) float64 { return a * b },
+	}
+	// Integers that do not divide evenly give a rational
+	division = arithmeticOp{
+		verb: "Can't divide",
+		integer: func(a, b int64) (int64, bool) {
+			return a / b, a%b == 0 && !(a == math.MinInt64 && b == -1)
+		},
+		rational: (*big.Rat).Quo,
+		real:     func(a, b float64) float64 { return a / b },
+	}
+)
+
+// comparisonOp compares numbers. Exact numbers are compared exactly, giving
+// exact the sign of a - b, and reals with real.
+type comparisonOp struct {
+	exact func(cmp int) bool
+	real  func(a, b float64) bool
+}
+
+// apply compares a and b, or reports false if one of them is not a number.
+func (op comparisonOp) apply(a, b Literal) (Element, bool) {
+	switch commonKind(a, b) {
+	case kindInteger:
+		x, y := a.(LiteralInteger).Value, b.(LiteralInteger).Value
+		cmp := 0
+		if x < y {
+			cmp = -1
+		} else if x > y {
+			cmp = 1
+		}
+		return LiteralBoolean{Value: op.exact(cmp)}, true
+	case kindBigInteger:
+		return LiteralBoolean{Value: op.exact(toBig(a).Cmp(toBig(b)))}, true
+	case kindRational:
+		return LiteralBoolean{Value: op.exact(toRat(a).Cmp(toRat(b)))}, true
+	case kindReal:
+		return LiteralBoolean{Value: op.real(toFloat(a), toFloat(b))}, true
+	}
+	return nil, false
+}
+
+var (
+	equality = comparisonOp{
+		exact: func(cmp int) bool { return cmp == 0 },
+		real:  func(a, b float64) bool { return a == b },
+	}
+	inequality = comparisonOp{
+		exact: func(cmp int) bool { return cmp != 0 },
+		real:  func(a, b float64) bool { return a != b },
+	}
+	lessThan = comparisonOp{
+		exact: func(cmp int) bool { return cmp < 0 },
+		real:  func(a, b float64) bool { return a < b },
+	}
+	lessOrEqual = comparisonOp{
+		exact: func(cmp int) bool { return cmp <= 0 },
+		real:  func(a, b float64) bool { return a <= b },
+	}
+	greaterThan = comparisonOp{
+		exact: func(cmp int) bool { return cmp > 0 },
+		real:  func(a, b float64) bool { return a > b },
+	}
+	greaterOrEqual = comparisonOp{
+		exact: func(cmp int) bool { return cmp >= 0 },
+		real:  func(a, b float64) bool { return a >= b },
+	}
+)
@@ -0,0 +1,182 @@
+package ast
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Elements print as flylang source. Printing an element produced by the
+// parser and parsing the result again gives an equal element.
+
+func (a Atom) String() string { return a.Name }
+
+func (l LiteralInteger) String() string    { return strconv.FormatInt(l.Value, 10) }
+func (l LiteralBigInteger) String() string { return l.Value.String() }
+func (l LiteralRational) String() string   { return l.Value.String() }
+
+func (l LiteralReal) String() string {
+	switch {
+	case math.IsNaN(l.Value):
+		return "+nan.0"
+	case math.IsInf(l.Value, 1):
+		return "+inf.0"
+	case math.IsInf(l.Value, -1):
+		return "-inf.0"
+	}
+	s := strconv.FormatFloat(l.Value, 'f', -1, 64)
+	if !strings.Contains(s, ".") { // keep reals distinguishable from integers
+		s += ".0"
+	}
+	return s
+}
+
+func (l LiteralBoolean) String() string { return strconv.FormatBool(l.Value) }
+func (l LiteralNull) String() string    { return "null" }
+func (l LiteralString) String() string  { return strconv.Quote(l.Value) }
+
+func (l LiteralList) String() string {
+	elements := make([]Element, len(l.Value))
+	for i, v := range l.Value {
+		elements[i] = v
+	}
+	return printList(elements)
+}
+
+func (l ListElement) String() string { return printList(l.Elements) }
+
+func (m MapElement) String() string { return printMap(m.Elements) }
+
+func (m Map) String() string {
+	elements := make([]Element, 0, 2*m.Len())
+	for _, e := range m.entries() {
+		elements = append(elements, e.elem, e.value)
+	}
+	return printMap(elements)
+}
+
+func (v VectorElement) String() string { return printSequence("#(", v.Elements, ")") }
+func (v Vector) String() string        { return printSequence("#(", v.Elements, ")") }
+
+func (p Program) String() string {
+	lines := make([]string, len(p.Elements))
+	for i, e := range p.Elements {
+		lines[i] = Print(e)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (q Quote) String() string { return "'" + Print(q.Element) }
+
+func (q Quasiquote) String() string      { return "`" + Print(q.Element) }
+func (u Unquote) String() string         { return "," + Print(u.Element) }
+func (u UnquoteSplicing) String() string { return ",@" + Print(u.Element) }
+
+func (s Setq) String() string {
+	return printList([]Element{Atom{Name: "setq"}, s.Atom, s.Element})
+}
+
+func (f Func) String() string {
+	return printList(append([]Element{Atom{Name: "func"}, f.Atom, f.List}, f.SubProg.Elements...))
+}
+
+func (l Lambda) String() string {
+	return printList(append([]Element{Atom{Name: "lambda"}, l.List}, l.SubProg.Elements...))
+}
+
+func (p Prog) String() string {
+	return printList(append([]Element{Atom{Name: "prog"}, p.List}, p.SubProg.Elements...))
+}
+
+func (cond Cond) String() string {
+	elements := []Element{Atom{Name: "cond"}}
+	for _, clause := range cond.Clauses {
+		test := clause.Test
+		if test == nil {
+			test = Atom{Name: "else"}
+		}
+		elements = append(elements, ListElement{Elements: append([]Element{test}, clause.Body.Elements...)})
+	}
+	return printList(elements)
+}
+
+func (i If) String() string {
+	elements := []Element{Atom{Name: "if"}, i.Test, i.Then}
+	if i.Else != nil {
+		elements = append(elements, i.Else)
+	}
+	return printList(elements)
+}
+
+func (l Let) String() string {
+	bindings := make([]Element, len(l.Bindings))
+	for i, b := range l.Bindings {
+		bindings[i] = ListElement{Elements: []Element{b.Name, b.Value}}
+	}
+	elements := []Element{Atom{Name: l.Kind.String()}, ListElement{Elements: bindings}}
+	return printList(append(elements, l.Body.Elements...))
+}
+
+func (l Logical) String() string {
+	return printList(append([]Element{Atom{Name: l.Kind.String()}}, l.Elements...))
+}
+
+func (w When) String() string {
+	return printList(append([]Element{Atom{Name: w.Kind.String()}, w.Test}, w.Body.Elements...))
+}
+
+func (d DefMacro) String() string {
+	return printList(append([]Element{Atom{Name: "defmacro"}, d.Atom, d.List}, d.SubProg.Elements...))
+}
+
+func (d DefineSyntax) String() string {
+	literals := make([]Element, len(d.Literals))
+	for i, l := range d.Literals {
+		literals[i] = l
+	}
+	rules := []Element{Atom{Name: "syntax-rules"}, ListElement{Elements: literals}}
+	for _, r := range d.Rules {
+		rules = append(rules, ListElement{Elements: []Element{r.Pattern, r.Template}})
+	}
+	return printList([]Element{Atom{Name: "define-syntax"}, d.Atom, ListElement{Elements: rules}})
+}
+
+func (w While) String() string {
+	return printList(append([]Element{Atom{Name: "while"}, w.Element1}, w.Element2.Elements...))
+}
+
+func (r Return) String() string {
+	return printList([]Element{Atom{Name: "return"}, r.Element})
+}
+
+func (b Break) String() string { return "(break)" }
+
+// Print returns the flylang source of an element.
+func Print(e Element) string {
+	if e == nil {
+		return ""
+	}
+	return fmt.Sprint(e)
+}
+
+func printList(elements []Element) string {
+	return printSequence("(", elements, ")")
+}
+
+func printMap(elements []Element) string {
+	return printSequence("{", elements, "}")
+}
+
+func printSequence(open string, elements []Element, close string) string {
+	var sb strings.Builder
+	sb.WriteString(open)
+	for i, e := range elements {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(Print(e))
+	}
+	sb.WriteString(close)
+	return sb.String()
+}
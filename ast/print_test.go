@@ -0,0 +1,122 @@
+package ast_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flychario/flylang/ast"
+	"github.com/flychario/flylang/parser"
+)
+
+func parse(name string, src []byte) ast.Program {
+	var p parser.Parser
+	p.Init(name, src)
+	return p.ParseProgram()
+}
+
+func TestPrint(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  string
+	}{
+		{"(setq a 1)", "(setq a 1)"},
+		{"(plus -1 +2.50)", "(plus -1 2.5)"},
+		{"(list +inf.0 -inf.0 -nan.0)", "(list +inf.0 -inf.0 +nan.0)"},
+		{"(quote (a b))", "'(a b)"},
+		{"'()", "'()"},
+		{"(func f (x y) (print x) y)", "(func f (x y) (print x) y)"},
+		{"(lambda () null)", "(lambda () null)"},
+		{"(prog (a) a)", "(prog (a) a)"},
+		{"(cond (less a b) a)", "(if (less a b) a)"},
+		{"(cond (less a b) a b)", "(if (less a b) a b)"},
+		{"(if x 1 2)", "(if x 1 2)"},
+		{"(cond ((less a b) a) (else (print b) b))", "(cond ((less a b) a) (else (print b) b))"},
+		{"(let* ((a 1) (b a)) (print a) b)", "(let* ((a 1) (b a)) (print a) b)"},
+		{"(letrec () 1)", "(letrec () 1)"},
+		{"(and a (or b c))", "(and a (or b c))"},
+		{"(unless (f) (g) 1)", "(unless (f) (g) 1)"},
+		{"`(a ,b ,@(c) `(d ,,e))", "`(a ,b ,@(c) `(d ,,e))"},
+		{"(while true (break) (return 1.0))", "(while true (break) (return 1.0))"},
+		{`(concat "a\"b" "\n")`, `(concat "a\"b" "\n")`},
+		{`{"a" (f x) b  1}`, `{"a" (f x) b 1}`},
+		{"#( 1 (f x) )", "#(1 (f x))"},
+		{"(setq a 1)\n\n(a  b)", "(setq a 1)\n(a b)"},
+	} {
+		res := parse("test", []byte(test.input)).String()
+		if res != test.want {
+			t.Errorf("expected %s, got %s", test.want, res)
+		}
+	}
+}
+
+func TestPrintValues(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  string
+	}{
+		{"(cons 1 '(2 3))", "(1 2 3)"},
+		{"(divide 1.0 4)", "0.25"},
+		{"(divide 2 -6)", "-1/3"},
+		{"(times 2.0 2)", "4.0"},
+		{`(split "a b" " ")`, `("a" "b")`},
+		{"(divide 1.0 0)", "+inf.0"},
+		{"(divide -1.0 0)", "-inf.0"},
+		{"(minus (divide 1.0 0) (divide 1.0 0))", "+nan.0"},
+		{`(string->number "-inf.0")`, "-inf.0"},
+		{"(lambda (x) (plus x 1))", "(lambda (x) (plus x 1))"},
+		{"plus", "plus"},
+		{"'(quote x)", "'x"},
+		{`(put {"a" 1 'b 2} "a" 3)`, `{"a" 3 b 2}`},
+		{"(merge {1 2} '{x (1 2)})", "{1 2 x (1 2)}"},
+		{"(vector-set #(1 (plus 1 1)) 0 '#(a))", "#(#(a) 2)"},
+		{"`(1 ,(plus 1 1) ,@'(3 4) `(5 ,(6 ,(plus 3 4))))", "(1 2 3 4 `(5 ,(6 7)))"},
+	} {
+		res, err := parse("test", []byte(test.input)).Eval(ast.GetGlobalContext())
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+		} else if ast.Print(res) != test.want {
+			t.Errorf("%s: expected %s, got %s", test.input, test.want, ast.Print(res))
+		}
+	}
+}
+
+// A context without a parent evaluates the special forms
+func TestNewContext(t *testing.T) {
+	res, err := parse("test", []byte("(if 1 (setq x 2)) (cond (null 3) (true x))")).Eval(ast.NewContext(nil))
+	if err != nil || ast.Print(res) != "2" {
+		t.Errorf("expected 2, got %v %v", res, err)
+	}
+}
+
+// Printing a program and parsing the result gives the same program
+func TestPrintRoundTrip(t *testing.T) {
+	files, _ := filepath.Glob("../tests/*.fly")
+	samples, _ := filepath.Glob("../samples/*.fly")
+	if len(files) == 0 || len(samples) == 0 {
+		t.Fatal("no programs found")
+	}
+	programs := map[string]string{
+		"numbers":    "(list 1/3 -3/2 0.375 +inf.0 -inf.0 +nan.0 123456789012345678901234567890)",
+		"maps":       `(setq m {"a" 1 'k {}}) (put m 2.5 '{k x}) ` + "`{k ,x}",
+		"vectors":    "(setq v #(1 (plus 1 1) \"three\")) '#(0 #()) `#(a ,x ,@'(5 6))",
+		"quasiquote": "`(1 ,x ,@ys `(a ,(b ,c)))",
+		"forms": `(func f (a &optional (b 1) &rest c) (cond ((less a b) 1) (else (let* ((x a)) (when (and x (or)) x)))))
+(defmacro m (x) ` + "`(unless ,x null)" + `)`,
+		"syntax-rules": `(define-syntax for (syntax-rules (in) ((_ x in xs body ...) (let ((items xs)) (while items body ...)))))`,
+	}
+	for _, file := range append(files, samples...) {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		programs[file] = string(src)
+	}
+	for name, src := range programs {
+		program := parse(name, []byte(src))
+		printed := ast.Print(program)
+		if reparsed := parse(name, []byte(printed)); !ast.Equal(program, reparsed) {
+			t.Errorf("%s: printed program differs:\n%s", name, printed)
+		}
+	}
+}
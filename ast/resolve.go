@@ -0,0 +1,316 @@
+package ast
+
+import "github.com/flychario/flylang/token"
+
+// Resolve returns e with the atoms naming variables of lambdas and lets
+// annotated with their lexical addresses, so they are found in the slots of
+// their contexts instead of by name. Globals, variables defined at run time
+// by func or setq and the variables seen by prog bodies, whose contexts are
+// those of their callers, are still looked up by name, as is code run by
+// eval. Quoted data is left alone.
+func Resolve(e Element) Element {
+	var r resolver
+	return r.resolve(e)
+}
+
+type resolver struct {
+	scope *scope // innermost scope, nil at top level
+}
+
+// scope is the context of a lambda call or of a let body.
+type scope struct {
+	parent  *scope
+	names   []string        // names of the slots bound so far
+	defined map[string]bool // names func may add to the context
+	dynamic bool            // the contexts around are only known at run time
+}
+
+// lookup returns the address of the variable name refers to, if it is bound
+// to a slot.
+func (r *resolver) lookup(name string) Address {
+	depth := 0
+	for s := r.scope; s != nil && !s.dynamic; s = s.parent {
+		for i := len(s.names) - 1; i >= 0; i-- {
+			if s.names[i] == name {
+				return Address{Depth: depth, Index: i, Resolved: true}
+			}
+		}
+		if s.defined[name] {
+			break
+		}
+		depth++
+	}
+	return Address{}
+}
+
+// enter starts a new scope whose context may get names defined by func
+// forms in elements.
+func (r *resolver) enter(elements []Element) *scope {
+	s := &scope{parent: r.scope, defined: make(map[string]bool)}
+	for _, e := range elements {
+		WalkDefinitions(e, func(name string, isFunc bool) {
+			if isFunc {
+				s.defined[name] = true
+			}
+		})
+	}
+	r.scope = s
+	return s
+}
+
+func (r *resolver) leave() {
+	r.scope = r.scope.parent
+}
+
+func (r *resolver) resolve(e Element) Element {
+	switch e := e.(type) {
+	case Atom:
+		e.Address = r.lookup(e.Name)
+		return e
+	case ListElement:
+		e.Elements = r.resolveAll(e.Elements)
+		return e
+	case MapElement:
+		e.Elements = r.resolveAll(e.Elements)
+		return e
+	case VectorElement:
+		e.Elements = r.resolveAll(e.Elements)
+		return e
+	case Program:
+		return r.resolveProgram(e)
+	case Quasiquote:
+		e.Element = r.resolveTemplate(e.Element, 1)
+		return e
+	case Setq:
+		e.Atom.Address = r.lookup(e.Atom.Name)
+		e.Element = r.resolve(e.Element)
+		return e
+	case Func:
+		e.List, e.SubProg, e.Names = r.function(e.List, e.SubProg)
+		return e
+	case Lambda:
+		e.List, e.SubProg, e.Names = r.function(e.List, e.SubProg)
+		return e
+	case Prog:
+		r.scope = &scope{parent: r.scope, dynamic: true}
+		defer r.leave()
+		e.SubProg = r.resolveProgram(e.SubProg)
+		return e
+	case Cond:
+		clauses := make([]Clause, len(e.Clauses))
+		for i, clause := range e.Clauses {
+			if clause.Test != nil {
+				clause.Test = r.resolve(clause.Test)
+			}
+			clause.Body = r.resolveProgram(clause.Body)
+			clauses[i] = clause
+		}
+		e.Clauses = clauses
+		return e
+	case If:
+		e.Test, e.Then = r.resolve(e.Test), r.resolve(e.Then)
+		if e.Else != nil {
+			e.Else = r.resolve(e.Else)
+		}
+		return e
+	case Let:
+		return r.let(e)
+	case Logical:
+		e.Elements = r.resolveAll(e.Elements)
+		return e
+	case When:
+		e.Test = r.resolve(e.Test)
+		e.Body = r.resolveProgram(e.Body)
+		return e
+	case While:
+		e.Element1 = r.resolve(e.Element1)
+		e.Element2 = r.resolveProgram(e.Element2)
+		return e
+	case Return:
+		e.Element = r.resolve(e.Element)
+		return e
+	}
+	return e
+}
+
+// function resolves a lambda, whose parameters are bound to the slots of
+// its calls in order. Defaults of optional parameters see the parameters
+// before them.
+func (r *resolver) function(params List, body Program) (List, Program, []string) {
+	s := r.enter(body.Elements)
+	defer r.leave()
+	s.names = []string{}
+
+	elements := make([]Element, len(params.GetElements()))
+	for i, param := range params.GetElements() {
+		switch p := param.(type) {
+		case Atom:
+			if p.Name != "&optional" && p.Name != "&rest" {
+				s.names = append(s.names, p.Name)
+			}
+		case ListElement:
+			if len(p.Elements) != 2 {
+				break
+			}
+			if name, ok := p.Elements[0].(Atom); ok {
+				p.Elements = []Element{name, r.resolve(p.Elements[1])}
+				param = p
+				s.names = append(s.names, name.Name)
+			}
+		}
+		elements[i] = param
+	}
+	if list, ok := params.(ListElement); ok {
+		list.Elements = elements
+		params = list
+	}
+	return params, r.resolveProgram(body), s.names
+}
+
+// let resolves a let, whose bindings are the slots of its body's context
+// in order. The values of a let are outside of it, those of let* see the
+// bindings before them and those of letrec all of them.
+func (r *resolver) let(l Let) Element {
+	bindings := make([]Binding, len(l.Bindings))
+	copy(bindings, l.Bindings)
+	var s *scope
+	if l.Kind == token.LET {
+		for i := range bindings {
+			bindings[i].Value = r.resolve(bindings[i].Value)
+		}
+		s = r.enter(l.Body.Elements)
+	} else {
+		s = r.enter(append(bindingValues(bindings), l.Body.Elements...))
+	}
+	defer r.leave()
+
+	s.names = make([]string, 0, len(bindings))
+	if l.Kind == token.LETREC {
+		for _, b := range bindings {
+			s.names = append(s.names, b.Name.Name)
+		}
+	}
+	for i := range bindings {
+		if l.Kind != token.LET {
+			bindings[i].Value = r.resolve(bindings[i].Value)
+		}
+		if l.Kind != token.LETREC {
+			s.names = append(s.names, bindings[i].Name.Name)
+		}
+	}
+	l.Bindings = bindings
+	l.Names = s.names
+	l.Body = r.resolveProgram(l.Body)
+	return l
+}
+
+func bindingValues(bindings []Binding) []Element {
+	values := make([]Element, len(bindings))
+	for i, b := range bindings {
+		values[i] = b.Value
+	}
+	return values
+}
+
+// resolveTemplate resolves the elements of a quasiquote template that are
+// evaluated, the ones unquoted at depth 1.
+func (r *resolver) resolveTemplate(e Element, depth int) Element {
+	switch e := e.(type) {
+	case ListElement:
+		e.Elements = r.resolveTemplates(e.Elements, depth)
+		return e
+	case MapElement:
+		e.Elements = r.resolveTemplates(e.Elements, depth)
+		return e
+	case VectorElement:
+		e.Elements = r.resolveTemplates(e.Elements, depth)
+		return e
+	case Quasiquote:
+		e.Element = r.resolveTemplate(e.Element, depth+1)
+		return e
+	case Unquote:
+		e.Element = r.resolveUnquoted(e.Element, depth)
+		return e
+	case UnquoteSplicing:
+		e.Element = r.resolveUnquoted(e.Element, depth)
+		return e
+	}
+	return e
+}
+
+func (r *resolver) resolveTemplates(elements []Element, depth int) []Element {
+	resolved := make([]Element, len(elements))
+	for i, elem := range elements {
+		resolved[i] = r.resolveTemplate(elem, depth)
+	}
+	return resolved
+}
+
+func (r *resolver) resolveUnquoted(e Element, depth int) Element {
+	if depth == 1 {
+		return r.resolve(e)
+	}
+	return r.resolveTemplate(e, depth-1)
+}
+
+func (r *resolver) resolveAll(elements []Element) []Element {
+	resolved := make([]Element, len(elements))
+	for i, elem := range elements {
+		resolved[i] = r.resolve(elem)
+	}
+	return resolved
+}
+
+func (r *resolver) resolveProgram(p Program) Program {
+	p.Elements = r.resolveAll(p.Elements)
+	return p
+}
+
+// WalkDefinitions calls visit for the setq and func forms evaluated in the
+// context of e, which may define variables in it.
+func WalkDefinitions(e Element, visit func(name string, isFunc bool)) {
+	walkAll := func(elements []Element) {
+		for _, elem := range elements {
+			WalkDefinitions(elem, visit)
+		}
+	}
+	switch e := e.(type) {
+	case Setq:
+		visit(e.Atom.Name, false)
+		WalkDefinitions(e.Element, visit)
+	case Func:
+		visit(e.Atom.Name, true)
+	case ListElement:
+		walkAll(e.Elements)
+	case MapElement:
+		walkAll(e.Elements)
+	case VectorElement:
+		walkAll(e.Elements)
+	case Quasiquote:
+		walkAll(e.Unquoted())
+	case Cond:
+		for _, clause := range e.Clauses {
+			WalkDefinitions(clause.Test, visit)
+			walkAll(clause.Body.Elements)
+		}
+	case If:
+		walkAll([]Element{e.Test, e.Then, e.Else})
+	case Let:
+		// Only the values of let are evaluated outside of its scope
+		if e.Kind == token.LET {
+			for _, b := range e.Bindings {
+				WalkDefinitions(b.Value, visit)
+			}
+		}
+	case Logical:
+		walkAll(e.Elements)
+	case When:
+		WalkDefinitions(e.Test, visit)
+		walkAll(e.Body.Elements)
+	case While:
+		WalkDefinitions(e.Element1, visit)
+		walkAll(e.Element2.Elements)
+	case Return:
+		WalkDefinitions(e.Element, visit)
+	}
+}
@@ -0,0 +1,73 @@
+package ast_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/flychario/flylang/ast"
+)
+
+// addresses lists the atoms of a resolved element with their addresses.
+func addresses(e ast.Element) string {
+	var out []string
+	var walk func(e ast.Element)
+	walkAll := func(elements []ast.Element) {
+		for _, elem := range elements {
+			walk(elem)
+		}
+	}
+	walk = func(e ast.Element) {
+		switch e := e.(type) {
+		case ast.Atom:
+			if e.Resolved {
+				out = append(out, fmt.Sprintf("%s@%d.%d", e.Name, e.Depth, e.Index))
+			} else {
+				out = append(out, e.Name)
+			}
+		case ast.ListElement:
+			walkAll(e.Elements)
+		case ast.Program:
+			walkAll(e.Elements)
+		case ast.Quasiquote:
+			walkAll(e.Unquoted())
+		case ast.Setq:
+			walk(e.Atom)
+			walk(e.Element)
+		case ast.Lambda:
+			walk(e.SubProg)
+		case ast.Func:
+			walk(e.SubProg)
+		case ast.Let:
+			for _, b := range e.Bindings {
+				walk(b.Value)
+			}
+			walk(e.Body)
+		}
+	}
+	walk(e)
+	return strings.Join(out, " ")
+}
+
+func TestResolve(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  string
+	}{
+		{"(lambda (a b) (plus a b g))", "plus a@0.0 b@0.1 g"},
+		{"(lambda (a) (let ((a (a 1)) (b a)) (lambda () (a b))))", "a@0.0 a@0.0 a@1.0 b@1.1"},
+		{"(lambda (a) (let* ((b a) (a b)) (a b)))", "a@1.0 b@0.0 a@0.1 b@0.0"},
+		{"(lambda (x &optional (y x) &rest z) (setq x (list y z)))", "x@0.0 list y@0.1 z@0.2"},
+		// f may be defined in the context of the let by func
+		{"(lambda (f) (let () (func f () 1) (f)) (f))", "f f@0.0"},
+		// the caller of a prog gives the context of its body
+		{"(lambda (a) (prog () a))", ""},
+		{"(setq a '(a b))", "a"},
+		{"(lambda (a) `(a ,a ,@(f a)))", "a@0.0 f a@0.0"},
+	} {
+		got := addresses(ast.Resolve(parse("test", []byte(test.input)).Elements[0]))
+		if got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.input, test.want, got)
+		}
+	}
+}
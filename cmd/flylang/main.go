@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/flychario/flylang"
+	"github.com/flychario/flylang/ast"
+)
+
+var useVM = flag.Bool("vm", false, "compile programs to bytecode and run them on the virtual machine")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-vm] [FILE | repl]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var opts []flylang.Option
+	if *useVM {
+		opts = append(opts, flylang.WithVM())
+	}
+	if flag.NArg() < 1 || flag.Arg(0) == "repl" {
+		runRepl(os.Stdin, os.Stdout, opts...)
+		return
+	}
+	fileName := flag.Arg(0)
+
+	runRes := run(fileName, opts...)
+	fmt.Println(runRes)
+}
+
+func run(fileName string, opts ...flylang.Option) string {
+	res, err := flylang.New(opts...).EvalFile(fileName)
+	if err != nil {
+		printError(os.Stdout, err)
+	}
+
+	return fmt.Sprintf("\n%v", res)
+}
+
+// printError prints runtime errors with their traceback.
+func printError(out io.Writer, err error) {
+	if rerr, ok := err.(*ast.RuntimeError); ok {
+		fmt.Fprintln(out, rerr.Traceback())
+	} else {
+		fmt.Fprintln(out, err)
+	}
+}
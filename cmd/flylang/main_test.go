@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	in := `(setq x 5)
+(setq y
+    (plus x 1))
+(plus y z)
+:env
+"two
+lines"
+:history
+:history 2
+:load ../../samples/eval.fly
+:nope
+:quit
+(plus 1 2)
+`
+	want := `fly> 5
+fly> ...> 6
+fly> <repl>:1:9: undefined variable: z
+fly> x = 5
+y = 6
+fly> ...> "two\nlines"
+fly>    1  (setq x 5)
+   2  (setq y
+    (plus x 1))
+   3  (plus y z)
+   4  "two
+lines"
+fly> 6
+fly> 15
+fly> unknown command :nope, see :help
+fly> `
+
+	var out strings.Builder
+	runRepl(strings.NewReader(in), &out)
+	if out.String() != want {
+		t.Errorf("expected output\n%s\ngot\n%s", want, out.String())
+	}
+}
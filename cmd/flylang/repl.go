@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/flychario/flylang"
+	"github.com/flychario/flylang/ast"
+	"github.com/flychario/flylang/scanner"
+	"github.com/flychario/flylang/token"
+)
+
+const (
+	prompt             = "fly> "
+	continuationPrompt = "...> "
+)
+
+const replHelp = `Enter flylang expressions to evaluate them. Input continues on the next
+line until all parentheses are closed.
+
+Commands:
+  :help          show this help
+  :env           list the names defined in this session
+  :load FILE     evaluate a file in this session
+  :history       list previous inputs
+  :history N     evaluate input N again
+  :quit          leave the REPL (or press Ctrl-D)
+`
+
+// repl is an interactive session. All inputs are evaluated in one context,
+// so definitions carry over from one input to the next.
+type repl struct {
+	out     io.Writer
+	interp  *flylang.Interpreter
+	history []string
+}
+
+func runRepl(in io.Reader, out io.Writer, opts ...flylang.Option) {
+	opts = append([]flylang.Option{flylang.WithSourceName("<repl>")}, opts...)
+	r := &repl{out: out, interp: flylang.New(opts...)}
+	lines := bufio.NewScanner(in)
+
+	var input strings.Builder
+	fmt.Fprint(out, prompt)
+	for lines.Scan() {
+		input.WriteString(lines.Text())
+		input.WriteString("\n")
+		if isIncomplete([]byte(input.String())) {
+			fmt.Fprint(out, continuationPrompt)
+			continue
+		}
+
+		src := strings.TrimSpace(input.String())
+		input.Reset()
+		if src == ":quit" || src == ":q" {
+			return
+		}
+		r.handle(src)
+		fmt.Fprint(out, prompt)
+	}
+	fmt.Fprintln(out)
+}
+
+func (r *repl) handle(src string) {
+	if src == "" {
+		return
+	}
+	if !strings.HasPrefix(src, ":") {
+		r.history = append(r.history, src)
+		r.print(r.interp.EvalString(src))
+		return
+	}
+
+	cmd, arg, _ := strings.Cut(src, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case ":help", ":h":
+		fmt.Fprint(r.out, replHelp)
+	case ":env":
+		r.printEnv()
+	case ":load":
+		r.print(r.interp.EvalFile(arg))
+	case ":history":
+		if arg == "" {
+			for i, entry := range r.history {
+				fmt.Fprintf(r.out, "%4d  %s\n", i+1, entry)
+			}
+			return
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(r.history) {
+			fmt.Fprintf(r.out, "no history entry %s\n", arg)
+			return
+		}
+		r.handle(r.history[n-1])
+	default:
+		fmt.Fprintf(r.out, "unknown command %s, see :help\n", cmd)
+	}
+}
+
+// print prints the result of an evaluation. Errors leave the session
+// intact.
+func (r *repl) print(res ast.Element, err error) {
+	if err != nil {
+		printError(r.out, err)
+	} else if res != nil {
+		fmt.Fprintln(r.out, res)
+	}
+}
+
+func (r *repl) printEnv() {
+	for _, name := range r.interp.Names() {
+		value, _ := r.interp.Lookup(name)
+		fmt.Fprintf(r.out, "%s = %v\n", name, value)
+	}
+}
+
+// isIncomplete reports whether src ends inside an open list, map, vector,
+// string or block comment, so the REPL has to read more lines.
+func isIncomplete(src []byte) (incomplete bool) {
+	defer func() {
+		if recover() != nil { // let the parser report illegal input
+			incomplete = false
+		}
+	}()
+
+	var s scanner.Scanner
+	s.Init(src)
+	depth := 0
+	for {
+		_, tok, lit := s.Scan()
+		switch tok {
+		case token.EOF:
+			return depth > 0
+		case token.LPAREN, token.LBRACE, token.VECTOR:
+			depth++
+		case token.RPAREN, token.RBRACE:
+			depth--
+		case token.ILLEGAL:
+			if strings.HasPrefix(lit, `"`) || strings.HasPrefix(lit, "#|") {
+				return true
+			}
+		}
+	}
+}
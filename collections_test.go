@@ -0,0 +1,81 @@
+package flylang
+
+import "testing"
+
+func TestMaps(t *testing.T) {
+	prelude := `
+(setq ages {"ann" 31 "bob" (plus 20 7)})
+(setq older (put ages "bob" 28))
+
+(func count-words (words)
+    (setq counts {})
+    (while (not (isnull (head words)))
+        (setq word (head words))
+        (setq counts (put counts word (plus (cond (has counts word) (get counts word) 0) 1)))
+        (setq words (tail words)))
+    counts)
+
+(setq counts (count-words (split "a b a c b a" " ")))
+(setq x 7)`
+
+	testExprs(t, prelude, []exprTest{
+		{"literal with an expression", `(get ages "bob")`, "27"},
+		{"put leaves the map", `(get older "bob")`, "28"},
+		{"size", "(size ages)", "2"},
+		{"get of a missing key", `(get ages "cyd")`, "null"},
+		{"built by put", "counts", `{"a" 3 "b" 2 "c" 1}`},
+		{"keys in insertion order", "(keys counts)", `("a" "b" "c")`},
+		{"values in insertion order", "(values counts)", "(3 2 1)"},
+		{"has", `(has counts "c")`, "true"},
+		{"del", `(del counts "b")`, `{"a" 3 "c" 1}`},
+		{"merge", `(merge {1 "one" 2 "two"} {2 "deux" 1.0 "real"})`, `{1 "one" 2 "deux" 1.0 "real"}`},
+		{"quoted literal", "(get '{k x} 'k)", "x"},
+		{"quasiquoted literal", "`{k ,x}", "{k 7}"},
+		{"nested quasiquote", "``{k ,x}", "`{k ,x}"},
+		{"ismap", "(ismap counts)", "true"},
+		{"ismap of a list", "(ismap '(a))", "false"},
+	})
+}
+
+func TestVectors(t *testing.T) {
+	prelude := `
+(func primes (n)
+    (setq sieve (make-vector n true))
+    (setq found '())
+    (setq i (minus n 1))
+    (while (greater i 1)
+        (setq j 2)
+        (while (lesseq (times i j) (minus n 1))
+            (vector-set sieve (times i j) false)
+            (setq j (plus j 1)))
+        (setq i (minus i 1)))
+    (setq i (minus n 1))
+    (while (greater i 1)
+        (when (vector-ref sieve i)
+            (setq found (cons i found)))
+        (setq i (minus i 1)))
+    found)
+
+(setq v #(1 (plus 1 1) "three"))
+(setq w v)
+(vector-set w 0 'one)
+(setq x 4)
+
+(func zeros () (vector-append '#(0 0)))`
+
+	testExprs(t, prelude, []exprTest{
+		{"sieve", "(primes 30)", "(2 3 5 7 11 13 17 19 23 29)"},
+		{"vector-set through another name", "v", `#(one 2 "three")`},
+		{"vector-ref", "(vector-ref v 1)", "2"},
+		{"vector-length", "(vector-length v)", "3"},
+		{"vector-append", "(vector-append v #(x) '#(x))", `#(one 2 "three" 4 x)`},
+		{"subvector", "(subvector v 1 3)", `#(2 "three")`},
+		{"list->vector", "(list->vector '(1 2))", "#(1 2)"},
+		{"vector->list of an empty vector", "(vector->list #())", "()"},
+		{"vector->list", "(vector->list v)", `(one 2 "three")`},
+		{"quasiquoted literal", "`#(a ,x ,@'(5 6))", "#(a 4 5 6)"},
+		{"copies of constants can change", "(vector-set (zeros) 0 1) (zeros)", "#(0 0)"},
+		{"isvector", "(isvector v)", "true"},
+		{"isvector of a list", "(isvector '(1))", "false"},
+	})
+}
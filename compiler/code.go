@@ -0,0 +1,129 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Opcode is an instruction of the virtual machine. Its operands follow it in
+// the code, each as a two byte big endian number.
+type Opcode byte
+
+const (
+	OpConst       Opcode = iota // push constant A
+	OpPop                       // drop the top of the stack
+	OpLocal                     // push slot B of the environment A levels up
+	OpSetLocal                  // store the top in slot B of the environment A levels up
+	OpGlobal                    // push the global named A
+	OpSetGlobal                 // assign the top to the global named A, defining it if needed
+	OpDefGlobal                 // define the global named A as the top
+	OpLookup                    // push the variable of reference A
+	OpSetq                      // assign the top to the variable of reference A
+	OpClosure                   // push a closure of function A over the current environment
+	OpCall                      // call the function below A arguments, at call site B
+	OpTailCall                  // like OpCall, but a closure replaces the calling frame
+	OpReturn                    // return the top from the function
+	OpHalt                      // end the program with the top as its value
+	OpJump                      // jump to A
+	OpJumpIfFalse               // pop the test of form B and jump to A if it is false
+	OpAnd                       // jump to A keeping the top if it is false, otherwise pop it
+	OpOr                        // jump to A keeping the top if it is true, otherwise pop it
+	OpTruth                     // check that the top is a boolean
+	OpWhen                      // pop a test and jump to A if it is false
+	OpUnless                    // pop a test and jump to A if it is true
+	OpPushEnv                   // enter a new environment with the slots of scope A
+	OpPopEnv                    // leave the current environment
+	OpQuasiquote                // replace A values by quasiquote constant B filled with them
+	OpMap                       // replace A values, keys each followed by its value, by their map
+	OpVector                    // replace A values by their vector
+	OpEval                      // push constant A evaluated by the tree-walking evaluator
+	OpFail                      // raise an error of kind A with message constant B
+	OpBreakError                // raise the error of a break outside of a while
+	OpArg                       // bind argument A to slot B
+	OpOptArg                    // bind argument A to slot B and jump to C, if it was passed
+	OpRestArg                   // bind the list of the arguments from A on to slot B
+	OpArgsEnd                   // check that at most A arguments were passed
+)
+
+// The forms whose tests OpJumpIfFalse checks, by its operand B.
+const (
+	FormCond = iota
+	FormIf
+	FormWhile
+)
+
+// Forms names the forms of OpJumpIfFalse.
+var Forms = [...]string{FormCond: "cond", FormIf: "if", FormWhile: "while"}
+
+type definition struct {
+	name     string
+	operands int
+}
+
+var definitions = [...]definition{
+	OpConst:       {"CONST", 1},
+	OpPop:         {"POP", 0},
+	OpLocal:       {"LOCAL", 2},
+	OpSetLocal:    {"SETLOCAL", 2},
+	OpGlobal:      {"GLOBAL", 1},
+	OpSetGlobal:   {"SETGLOBAL", 1},
+	OpDefGlobal:   {"DEFGLOBAL", 1},
+	OpLookup:      {"LOOKUP", 1},
+	OpSetq:        {"SETQ", 1},
+	OpClosure:     {"CLOSURE", 1},
+	OpCall:        {"CALL", 2},
+	OpTailCall:    {"TAILCALL", 2},
+	OpReturn:      {"RETURN", 0},
+	OpHalt:        {"HALT", 0},
+	OpJump:        {"JUMP", 1},
+	OpJumpIfFalse: {"JUMPIFFALSE", 2},
+	OpAnd:         {"AND", 1},
+	OpOr:          {"OR", 1},
+	OpTruth:       {"TRUTH", 0},
+	OpWhen:        {"WHEN", 1},
+	OpUnless:      {"UNLESS", 1},
+	OpPushEnv:     {"PUSHENV", 1},
+	OpPopEnv:      {"POPENV", 0},
+	OpQuasiquote:  {"QUASIQUOTE", 2},
+	OpMap:         {"MAP", 1},
+	OpVector:      {"VECTOR", 1},
+	OpEval:        {"EVAL", 1},
+	OpFail:        {"FAIL", 2},
+	OpBreakError:  {"BREAKERROR", 0},
+	OpArg:         {"ARG", 2},
+	OpOptArg:      {"OPTARG", 3},
+	OpRestArg:     {"RESTARG", 2},
+	OpArgsEnd:     {"ARGSEND", 1},
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(definitions) {
+		return definitions[op].name
+	}
+	return fmt.Sprintf("OP(%d)", byte(op))
+}
+
+// Width returns the number of bytes taken by an instruction with the opcode.
+func (op Opcode) Width() int {
+	return 1 + 2*definitions[op].operands
+}
+
+// Operand returns operand i of the instruction at ip.
+func Operand(code []byte, ip, i int) int {
+	return int(binary.BigEndian.Uint16(code[ip+1+2*i:]))
+}
+
+// Disassemble lists the instructions of code, one per line.
+func Disassemble(code []byte) string {
+	var sb strings.Builder
+	for ip := 0; ip < len(code); ip += Opcode(code[ip]).Width() {
+		op := Opcode(code[ip])
+		fmt.Fprintf(&sb, "%04d %s", ip, op)
+		for i := 0; i < definitions[op].operands; i++ {
+			fmt.Fprintf(&sb, " %d", Operand(code, ip, i))
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
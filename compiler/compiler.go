@@ -0,0 +1,690 @@
+// Package compiler lowers flylang programs into bytecode for the virtual
+// machine of package vm.
+//
+// Variables are resolved when a program is compiled. Each call of a lambda
+// and each let gets an environment with a slot for every variable bound in
+// it, and a variable is addressed by the number of environments to go up and
+// its slot. As in the evaluator, setq assigns the nearest variable of a name,
+// or defines it in the innermost environment, and func defines its name in
+// the innermost environment, so the names they define get slots as well.
+// Such a slot is empty until it is assigned, and a lookup goes on past it to
+// the enclosing environments and then to the globals, which are kept by name
+// in the global context.
+package compiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/flychario/flylang/ast"
+	"github.com/flychario/flylang/token"
+)
+
+type Mode uint
+
+const (
+	// StrictBooleans compiles for an interpreter with strict booleans, where
+	// the last operand of and and or is checked and not in tail position.
+	StrictBooleans Mode = 1 << iota
+)
+
+// Function is the bytecode of a lambda or of a top-level element of a
+// program.
+type Function struct {
+	ast.Span
+	Params ast.List    // parameters of the lambda
+	Body   ast.Program // body of the lambda, kept for printing
+	Scope  *Scope      // slots of the environment of a call, nil at top level
+
+	Code      []byte
+	Consts    []ast.Element
+	Names     []string // names of globals
+	Refs      []Ref
+	Functions []*Function // lambdas defined in the function
+	Scopes    []*Scope    // scopes of let forms
+	Sites     []Site
+	Positions []Position // sorted by offset
+}
+
+// Scope lists the names of the slots of an environment.
+type Scope struct {
+	Names []string
+}
+
+// Ref is a variable that may be held by any of Slots, innermost first. The
+// first slot holding a value is the variable, and if there is none, the
+// global of the name.
+type Ref struct {
+	Name  string
+	Slots []Slot
+}
+
+// Slot is slot Index of the environment Depth levels up.
+type Slot struct {
+	Depth, Index int
+}
+
+// Site is a call, with the name of the called function if it is called by
+// name.
+type Site struct {
+	Name string
+	Pos  token.Position
+}
+
+// Position is the position of the element an instruction was compiled from.
+type Position struct {
+	Offset int
+	Pos    token.Position
+}
+
+// PosAt returns the position recorded for the instruction at ip, if any.
+func (f *Function) PosAt(ip int) token.Position {
+	i := sort.Search(len(f.Positions), func(i int) bool { return f.Positions[i].Offset >= ip })
+	if i < len(f.Positions) && f.Positions[i].Offset == ip {
+		return f.Positions[i].Pos
+	}
+	return token.Position{}
+}
+
+// String prints the lambda the function was compiled from.
+func (f *Function) String() string {
+	return ast.Lambda{List: f.Params, SubProg: f.Body}.String()
+}
+
+// Compile compiles a top-level element of a program whose macros have been
+// expanded.
+func Compile(e ast.Element, mode Mode) (fn *Function, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rerr, ok := r.(*ast.RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			fn, err = nil, rerr
+		}
+	}()
+
+	c := newCompiler(&Function{Span: ast.Span{StartPos: e.Pos(), EndPos: e.End()}}, nil, mode)
+	c.compile(e, false)
+	c.emit(OpReturn)
+	return c.fn, nil
+}
+
+type compiler struct {
+	fn    *Function
+	mode  Mode
+	scope *scope // innermost scope, nil at top level
+	names map[string]int
+	depth int // number of values on the stack
+	envs  int // number of let environments entered in the function
+	loops []*loop
+}
+
+type scope struct {
+	parent *scope
+	vars   map[string]*variable
+	info   *Scope
+}
+
+type variable struct {
+	index  int
+	static bool // bound by a parameter or a let, not by setq or func
+	bound  bool // static and bound at this point of the code
+}
+
+// loop is a while whose body is being compiled.
+type loop struct {
+	depth, envs int
+	breaks      []int // jumps of breaks to patch
+}
+
+func newCompiler(fn *Function, parent *scope, mode Mode) *compiler {
+	c := &compiler{fn: fn, mode: mode, names: make(map[string]int)}
+	if fn.Scope != nil {
+		c.scope = &scope{parent: parent, vars: make(map[string]*variable), info: fn.Scope}
+	}
+	return c
+}
+
+func (s *scope) declare(name string, static bool) *variable {
+	if v, ok := s.vars[name]; ok {
+		return v
+	}
+	v := &variable{index: len(s.info.Names), static: static}
+	s.vars[name] = v
+	s.info.Names = append(s.info.Names, name)
+	return v
+}
+
+func (c *compiler) compile(e ast.Element, tail bool) {
+	switch e := e.(type) {
+	case ast.Atom:
+		c.load(e)
+	case ast.Literal:
+		c.constant(e)
+	case ast.ListElement:
+		if len(e.Elements) == 0 {
+			c.constant(e)
+		} else {
+			c.call(e, tail)
+		}
+	case ast.Quote:
+		c.constant(e.Element)
+	case ast.Quasiquote:
+		unquoted := e.Unquoted()
+		for _, u := range unquoted {
+			c.compile(u, false)
+		}
+		c.emit(OpQuasiquote, len(unquoted), c.constIndex(e))
+	case ast.MapElement:
+		for _, elem := range e.Elements {
+			c.compile(elem, false)
+		}
+		c.mark(e.Pos())
+		c.emit(OpMap, len(e.Elements))
+	case ast.VectorElement:
+		for _, elem := range e.Elements {
+			c.compile(elem, false)
+		}
+		c.emit(OpVector, len(e.Elements))
+	case ast.Unquote:
+		c.fail(e, ast.ErrorRuntime, "unquote outside of quasiquote")
+	case ast.UnquoteSplicing:
+		c.fail(e, ast.ErrorRuntime, "unquote-splicing outside of quasiquote")
+	case ast.Setq:
+		c.compile(e.Element, false)
+		c.store(e.Atom)
+	case ast.Func:
+		c.closure(e.Span, e.List, e.SubProg)
+		c.define(e.Atom)
+		c.emit(OpPop)
+		c.constant(e.Atom)
+	case ast.Lambda:
+		c.closure(e.Span, e.List, e.SubProg)
+	case ast.Prog:
+		c.constant(e)
+	case ast.Cond:
+		c.cond(e, tail)
+	case ast.If:
+		c.ifElse(e, tail)
+	case ast.Let:
+		c.let(e, tail)
+	case ast.Logical:
+		c.logical(e, tail)
+	case ast.When:
+		c.when(e, tail)
+	case ast.While:
+		c.while(e)
+	case ast.Return:
+		c.compile(e.Element, false)
+		if c.fn.Scope == nil {
+			c.emit(OpHalt)
+		} else {
+			c.emit(OpReturn)
+		}
+	case ast.Break:
+		c.breakLoop()
+	case ast.DefMacro:
+		c.fail(e, ast.ErrorSyntax, fmt.Sprintf("macro %s defined without macro expansion", e.Atom.Name))
+	case ast.DefineSyntax:
+		c.fail(e, ast.ErrorSyntax, fmt.Sprintf("macro %s defined without macro expansion", e.Atom.Name))
+	default:
+		// Other elements, like values put into code by macros, are
+		// evaluated as they are
+		c.mark(e.Pos())
+		c.emit(OpEval, c.constIndex(e))
+	}
+}
+
+// body compiles the elements of a body, leaving the value of the last one,
+// or empty if there are none.
+func (c *compiler) body(elements []ast.Element, empty ast.Element, tail bool) {
+	if len(elements) == 0 {
+		c.constant(empty)
+		return
+	}
+	for _, e := range elements[:len(elements)-1] {
+		c.compile(e, false)
+		c.emit(OpPop)
+	}
+	c.compile(elements[len(elements)-1], tail)
+}
+
+func (c *compiler) call(l ast.ListElement, tail bool) {
+	for _, e := range l.Elements {
+		c.compile(e, false)
+	}
+	site := Site{Pos: l.Pos()}
+	if atom, ok := l.Elements[0].(ast.Atom); ok {
+		site.Name = atom.Name
+	}
+	c.fn.Sites = append(c.fn.Sites, site)
+	op := OpCall
+	if tail {
+		op = OpTailCall
+	}
+	c.emit(op, len(l.Elements)-1, len(c.fn.Sites)-1)
+}
+
+// resolve returns the slots that may hold the variable name, innermost
+// first, and whether the first of them surely does.
+func (c *compiler) resolve(name string) ([]Slot, bool) {
+	var slots []Slot
+	depth := 0
+	for s := c.scope; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			slots = append(slots, Slot{Depth: depth, Index: v.index})
+			if v.bound {
+				return slots, len(slots) == 1
+			}
+		}
+		depth++
+	}
+	return slots, false
+}
+
+func (c *compiler) load(a ast.Atom) {
+	slots, sure := c.resolve(a.Name)
+	c.mark(a.Pos())
+	switch {
+	case sure:
+		c.emit(OpLocal, slots[0].Depth, slots[0].Index)
+	case len(slots) == 0:
+		c.emit(OpGlobal, c.nameIndex(a.Name))
+	default:
+		c.emit(OpLookup, c.refIndex(a.Name, slots))
+	}
+}
+
+func (c *compiler) store(a ast.Atom) {
+	slots, sure := c.resolve(a.Name)
+	switch {
+	case sure:
+		c.emit(OpSetLocal, slots[0].Depth, slots[0].Index)
+	case c.scope == nil:
+		c.emit(OpSetGlobal, c.nameIndex(a.Name))
+	default:
+		c.emit(OpSetq, c.refIndex(a.Name, slots))
+	}
+}
+
+// define binds the name of a func in the innermost environment.
+func (c *compiler) define(a ast.Atom) {
+	if c.scope == nil {
+		c.emit(OpDefGlobal, c.nameIndex(a.Name))
+		return
+	}
+	c.emit(OpSetLocal, 0, c.scope.declare(a.Name, false).index)
+}
+
+// declareDefinitions gives the names that setq and func forms in elements
+// may define in the innermost scope a slot in it. The other scopes the forms
+// are in get their own slots.
+func (c *compiler) declareDefinitions(elements ...ast.Element) {
+	for _, e := range elements {
+		ast.WalkDefinitions(e, func(name string, isFunc bool) {
+			if isFunc || !c.isBound(name) {
+				c.scope.declare(name, false)
+			}
+		})
+	}
+}
+
+// isBound reports whether a variable of the name is surely bound, so setq
+// never defines it.
+func (c *compiler) isBound(name string) bool {
+	for s := c.scope; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok && v.bound {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiler) closure(span ast.Span, params ast.List, body ast.Program) {
+	fn := &Function{Span: span, Params: params, Body: body, Scope: &Scope{}}
+	newCompiler(fn, c.scope, c.mode).lambda(params.GetElements(), body)
+	c.fn.Functions = append(c.fn.Functions, fn)
+	c.emit(OpClosure, len(c.fn.Functions)-1)
+}
+
+// lambda compiles the binding of the arguments of a call, which follows
+// the evaluator: parameters after &optional may be missing and are bound to
+// null or to the value of their default, and a parameter after &rest is
+// bound to the list of the remaining arguments.
+func (c *compiler) lambda(params []ast.Element, body ast.Program) {
+	type param struct {
+		v    *variable
+		def  ast.Element
+		mode string
+	}
+	var bindings []param
+	mode := ""
+	defaults := []ast.Element{}
+	for _, p := range params {
+		if atom, ok := p.(ast.Atom); ok && (atom.Name == "&optional" || atom.Name == "&rest") {
+			mode = atom.Name
+			continue
+		}
+		name, def := parseParam(p)
+		bindings = append(bindings, param{v: c.scope.declare(name, true), def: def, mode: mode})
+		if def != nil {
+			defaults = append(defaults, def)
+		}
+	}
+	c.declareDefinitions(append(defaults, body.Elements...)...)
+
+	rest := false
+	for i, p := range bindings {
+		switch p.mode {
+		case "&rest":
+			c.emit(OpRestArg, i, p.v.index)
+			rest = true
+		case "&optional":
+			at := c.emit(OpOptArg, i, p.v.index, 0)
+			if p.def != nil {
+				c.compile(p.def, false)
+			} else {
+				c.constant(ast.LiteralNull{})
+			}
+			c.emit(OpSetLocal, 0, p.v.index)
+			c.emit(OpPop)
+			c.patchOperand(at, 2)
+		default:
+			c.emit(OpArg, i, p.v.index)
+		}
+		p.v.bound = true
+	}
+	if !rest {
+		c.emit(OpArgsEnd, len(bindings))
+	}
+
+	c.body(body.Elements, ast.LiteralNull{}, true)
+	c.emit(OpReturn)
+}
+
+// parseParam returns the name and default value of a parameter, which the
+// parser checked is a name or (name default).
+func parseParam(param ast.Element) (string, ast.Element) {
+	if p, ok := param.(ast.ListElement); ok {
+		return p.Elements[0].(ast.Atom).Name, p.Elements[1]
+	}
+	return param.(ast.Atom).Name, nil
+}
+
+func (c *compiler) cond(e ast.Cond, tail bool) {
+	depth := c.depth
+	var ends []int
+	hasElse := false
+	for _, clause := range e.Clauses {
+		c.depth = depth
+		if clause.Test == nil {
+			c.body(clause.Body.Elements, ast.LiteralBoolean{Value: true}, tail)
+			hasElse = true
+			break
+		}
+		c.compile(clause.Test, false)
+		c.mark(clause.Test.Pos())
+		next := c.emit(OpJumpIfFalse, 0, FormCond)
+		c.body(clause.Body.Elements, ast.LiteralBoolean{Value: true}, tail)
+		ends = append(ends, c.emit(OpJump, 0))
+		c.patch(next)
+	}
+	if !hasElse {
+		c.depth = depth
+		c.constant(ast.LiteralNull{})
+	}
+	for _, end := range ends {
+		c.patch(end)
+	}
+}
+
+func (c *compiler) ifElse(e ast.If, tail bool) {
+	c.compile(e.Test, false)
+	c.mark(e.Test.Pos())
+	next := c.emit(OpJumpIfFalse, 0, FormIf)
+	depth := c.depth
+	c.compile(e.Then, tail)
+	end := c.emit(OpJump, 0)
+	c.patch(next)
+	c.depth = depth
+	if e.Else != nil {
+		c.compile(e.Else, tail)
+	} else {
+		c.constant(ast.LiteralNull{})
+	}
+	c.patch(end)
+}
+
+func (c *compiler) let(l ast.Let, tail bool) {
+	if l.Kind == token.LET {
+		for _, b := range l.Bindings {
+			c.compile(b.Value, false)
+		}
+	}
+
+	info := &Scope{}
+	c.fn.Scopes = append(c.fn.Scopes, info)
+	c.emit(OpPushEnv, len(c.fn.Scopes)-1)
+	c.scope = &scope{parent: c.scope, vars: make(map[string]*variable), info: info}
+	c.envs++
+
+	vars := make([]*variable, len(l.Bindings))
+	for i, b := range l.Bindings {
+		vars[i] = c.scope.declare(b.Name.Name, true)
+	}
+	switch l.Kind {
+	case token.LET:
+		c.declareDefinitions(l.Body.Elements...)
+		// The values are on the stack, the last on top. A name bound
+		// twice gets the later value.
+		stored := make(map[*variable]bool)
+		for i := len(vars) - 1; i >= 0; i-- {
+			if !stored[vars[i]] {
+				c.emit(OpSetLocal, 0, vars[i].index)
+				stored[vars[i]] = true
+			}
+			c.emit(OpPop)
+		}
+		for _, v := range vars {
+			v.bound = true
+		}
+	case token.LETREC:
+		for _, v := range vars {
+			v.bound = true
+		}
+		c.declareDefinitions(append(bindingValues(l.Bindings), l.Body.Elements...)...)
+		c.constant(ast.LiteralNull{})
+		for _, v := range vars {
+			c.emit(OpSetLocal, 0, v.index)
+		}
+		c.emit(OpPop)
+		for i, b := range l.Bindings {
+			c.compile(b.Value, false)
+			c.emit(OpSetLocal, 0, vars[i].index)
+			c.emit(OpPop)
+		}
+	default:
+		c.declareDefinitions(append(bindingValues(l.Bindings), l.Body.Elements...)...)
+		for i, b := range l.Bindings {
+			c.compile(b.Value, false)
+			c.emit(OpSetLocal, 0, vars[i].index)
+			c.emit(OpPop)
+			vars[i].bound = true
+		}
+	}
+
+	c.body(l.Body.Elements, ast.LiteralNull{}, tail)
+	c.emit(OpPopEnv)
+	c.scope = c.scope.parent
+	c.envs--
+}
+
+func bindingValues(bindings []ast.Binding) []ast.Element {
+	values := make([]ast.Element, len(bindings))
+	for i, b := range bindings {
+		values[i] = b.Value
+	}
+	return values
+}
+
+func (c *compiler) logical(l ast.Logical, tail bool) {
+	if len(l.Elements) == 0 {
+		c.constant(ast.LiteralBoolean{Value: l.Kind == token.AND})
+		return
+	}
+	op := OpAnd
+	if l.Kind == token.OR {
+		op = OpOr
+	}
+	var ends []int
+	for _, e := range l.Elements[:len(l.Elements)-1] {
+		c.compile(e, false)
+		c.mark(e.Pos())
+		ends = append(ends, c.emit(op, 0))
+	}
+	last := l.Elements[len(l.Elements)-1]
+	if c.mode&StrictBooleans != 0 {
+		c.compile(last, false)
+		c.mark(last.Pos())
+		c.emit(OpTruth)
+	} else {
+		c.compile(last, tail)
+	}
+	for _, end := range ends {
+		c.patch(end)
+	}
+}
+
+func (c *compiler) when(w ast.When, tail bool) {
+	op := OpWhen
+	if w.Kind == token.UNLESS {
+		op = OpUnless
+	}
+	c.compile(w.Test, false)
+	c.mark(w.Test.Pos())
+	skip := c.emit(op, 0)
+	depth := c.depth
+	c.body(w.Body.Elements, ast.LiteralNull{}, tail)
+	end := c.emit(OpJump, 0)
+	c.patch(skip)
+	c.depth = depth
+	c.constant(ast.LiteralNull{})
+	c.patch(end)
+}
+
+func (c *compiler) while(w ast.While) {
+	depth := c.depth
+	start := len(c.fn.Code)
+	c.compile(w.Element1, false)
+	c.mark(w.Element1.Pos())
+	exit := c.emit(OpJumpIfFalse, 0, FormWhile)
+
+	// A break in the test is not caught by the loop
+	l := &loop{depth: depth, envs: c.envs}
+	c.loops = append(c.loops, l)
+	for _, e := range w.Element2.Elements {
+		c.compile(e, false)
+		c.emit(OpPop)
+	}
+	c.emit(OpJump, start)
+	c.loops = c.loops[:len(c.loops)-1]
+
+	c.patch(exit)
+	for _, b := range l.breaks {
+		c.patch(b)
+	}
+	c.depth = depth
+	c.constant(ast.LiteralNull{})
+}
+
+func (c *compiler) breakLoop() {
+	if len(c.loops) == 0 {
+		c.emit(OpBreakError)
+		return
+	}
+	l := c.loops[len(c.loops)-1]
+	depth := c.depth
+	for i := depth; i > l.depth; i-- {
+		c.emit(OpPop)
+	}
+	for i := c.envs; i > l.envs; i-- {
+		c.emit(OpPopEnv)
+	}
+	l.breaks = append(l.breaks, c.emit(OpJump, 0))
+	c.depth = depth + 1 // as if break had a value
+}
+
+func (c *compiler) fail(e ast.Element, kind ast.ErrorKind, message string) {
+	c.mark(e.Pos())
+	c.emit(OpFail, int(kind), c.constIndex(ast.LiteralString{Value: message}))
+}
+
+func (c *compiler) constant(e ast.Element) {
+	c.emit(OpConst, c.constIndex(e))
+}
+
+func (c *compiler) constIndex(e ast.Element) int {
+	c.fn.Consts = append(c.fn.Consts, e)
+	return len(c.fn.Consts) - 1
+}
+
+func (c *compiler) nameIndex(name string) int {
+	if i, ok := c.names[name]; ok {
+		return i
+	}
+	c.fn.Names = append(c.fn.Names, name)
+	c.names[name] = len(c.fn.Names) - 1
+	return len(c.fn.Names) - 1
+}
+
+func (c *compiler) refIndex(name string, slots []Slot) int {
+	c.fn.Refs = append(c.fn.Refs, Ref{Name: name, Slots: slots})
+	return len(c.fn.Refs) - 1
+}
+
+// mark records pos as the position of the next instruction.
+func (c *compiler) mark(pos token.Position) {
+	c.fn.Positions = append(c.fn.Positions, Position{Offset: len(c.fn.Code), Pos: pos})
+}
+
+// emit appends an instruction and returns its offset.
+func (c *compiler) emit(op Opcode, operands ...int) int {
+	at := len(c.fn.Code)
+	c.fn.Code = append(c.fn.Code, byte(op))
+	for _, o := range operands {
+		checkOperand(op, o)
+		c.fn.Code = binary.BigEndian.AppendUint16(c.fn.Code, uint16(o))
+	}
+
+	switch op {
+	case OpConst, OpLocal, OpGlobal, OpLookup, OpClosure, OpEval, OpFail, OpBreakError:
+		c.depth++
+	case OpPop, OpJumpIfFalse, OpAnd, OpOr, OpWhen, OpUnless:
+		c.depth--
+	case OpCall, OpTailCall:
+		c.depth -= operands[0]
+	case OpQuasiquote, OpMap, OpVector:
+		c.depth += 1 - operands[0]
+	}
+	return at
+}
+
+// patch makes the jump at offset at go to the end of the code.
+func (c *compiler) patch(at int) {
+	c.patchOperand(at, 0)
+}
+
+func (c *compiler) patchOperand(at, i int) {
+	checkOperand(Opcode(c.fn.Code[at]), len(c.fn.Code))
+	binary.BigEndian.PutUint16(c.fn.Code[at+1+2*i:], uint16(len(c.fn.Code)))
+}
+
+// checkOperand panics if o does not fit in an operand of op.
+func checkOperand(op Opcode, o int) {
+	if o < 0 || o > math.MaxUint16 {
+		panic(ast.NewRuntimeError(ast.ErrorRuntime, "function too large to compile: operand %d of %s", o, op))
+	}
+}
@@ -0,0 +1,101 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flychario/flylang/parser"
+)
+
+func compile(t *testing.T, src string) *Function {
+	t.Helper()
+	var p parser.Parser
+	p.Init("test", []byte(src))
+	fn, err := Compile(p.ParseProgram().Elements[0], 0)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return fn
+}
+
+func TestCompile(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  string
+	}{
+		{
+			"(lambda (x) (let ((y 1)) (setq x (plus x y)) (g x)))",
+			`0000 ARG 0 0
+0005 ARGSEND 1
+0008 CONST 0
+0011 PUSHENV 0
+0014 SETLOCAL 0 0
+0019 POP
+0020 GLOBAL 0
+0023 LOCAL 1 0
+0028 LOCAL 0 0
+0033 CALL 2 0
+0038 SETLOCAL 1 0
+0043 POP
+0044 GLOBAL 1
+0047 LOCAL 1 0
+0052 TAILCALL 1 1
+0057 POPENV
+0058 RETURN
+`,
+		},
+		{
+			// z is local once the setq ran, global before
+			"(lambda (x) (if x (setq z 1)) z)",
+			`0000 ARG 0 0
+0005 ARGSEND 1
+0008 LOCAL 0 0
+0013 JUMPIFFALSE 27 1
+0018 CONST 0
+0021 SETQ 0
+0024 JUMP 30
+0027 CONST 1
+0030 POP
+0031 LOOKUP 1
+0034 RETURN
+`,
+		},
+		{
+			"(lambda (a &optional (b a) &rest c) (return c))",
+			`0000 ARG 0 0
+0005 OPTARG 1 1 23
+0012 LOCAL 0 0
+0017 SETLOCAL 0 1
+0022 POP
+0023 RESTARG 2 2
+0028 LOCAL 0 2
+0033 RETURN
+0034 RETURN
+`,
+		},
+	} {
+		fn := compile(t, test.input).Functions[0]
+		if got := Disassemble(fn.Code); got != test.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.input, test.want, got)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	fn := compile(t, "(plus 1\n  (f 2))")
+	site := fn.Sites[Operand(fn.Code, 12, 1)]
+	if site.Name != "f" || site.Pos.String() != "test:2:3" {
+		t.Errorf("expected call site f at test:2:3, got %s at %v", site.Name, site.Pos)
+	}
+}
+
+// A jump past more code than an operand can address is an error
+func TestTooLarge(t *testing.T) {
+	src := "(if x (list" + strings.Repeat(" 1", 30000) + "))"
+	var p parser.Parser
+	p.Init("test", []byte(src))
+	want := "runtime error: function too large to compile: operand 90019 of JUMPIFFALSE"
+	if _, err := Compile(p.ParseProgram().Elements[0], 0); err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+}
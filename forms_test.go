@@ -0,0 +1,167 @@
+package flylang
+
+import "testing"
+
+func TestCond(t *testing.T) {
+	prelude := `
+(func classify (n)
+    (cond ((less n 0) "negative")
+          ((equal n 0) "zero")
+          ((less n 10)
+              (setq small (plus small 1))
+              "small")
+          (else "large")))
+
+(func sign (n)
+    (if (less n 0) -1 (if (equal n 0) 0 1)))
+
+(func count (x xs)
+    (cond ((isnull (head xs)) 0)
+          ((equal (head xs) x) (plus 1 (count x (tail xs))))
+          (else (count x (tail xs)))))`
+
+	testExprs(t, prelude, []exprTest{
+		{"first true clause", "(classify -5)", `"negative"`},
+		{"second clause", "(classify 0)", `"zero"`},
+		{"clause body in order", "(setq small 0) (cons (classify 3) (cons small '()))", `("small" 1)`},
+		{"else", "(classify 12)", `"large"`},
+		{"recursion in clauses", `(count "small" '("small" "zero" "small"))`, "2"},
+		{"no clause matches", "(cond ((less 1 0) 1))", "null"},
+		{"variable test clauses", "(setq flag true) (cond (flag 1) (true 2))", "1"},
+		{"single variable test clause", "(setq flag true) (cond (flag 2))", "2"},
+		{"older form", "(cond (isnull null) (plus 1 0) (plus 2 0))", "1"},
+		{"if then", "(sign -3)", "-1"},
+		{"nested if", "(sign 0)", "0"},
+		{"if else", "(sign 8)", "1"},
+		{"if without else", "(if false 1)", "null"},
+	})
+}
+
+func TestLet(t *testing.T) {
+	prelude := `
+(setq x 1)
+(setq y 2)
+
+(func parity (n)
+    (letrec ((even (lambda (n) (if (equal n 0) true (odd (minus n 1)))))
+             (odd (lambda (n) (if (equal n 0) false (even (minus n 1))))))
+        (even n)))`
+
+	testExprs(t, prelude, []exprTest{
+		{"let binds in parallel", "(let ((x y) (y x)) (plus (times 10 x) y))", "21"},
+		{"let keeps outer variables", "(let ((x 5)) (setq x 6)) x", "1"},
+		{"let* binds in sequence", "(let* ((a 1) (b (plus a 1)) (a (times a b 10))) a)", "20"},
+		{"letrec even", "(parity 100000)", "true"},
+		{"letrec odd", "(parity 7)", "false"},
+		{"empty let", "(let () null)", "null"},
+	})
+}
+
+func TestShortCircuit(t *testing.T) {
+	prelude := `
+(func positive-head (xs)
+    (and (not (isnull (head xs))) (greater (head xs) 0)))
+
+(setq calls 0)
+(func touch (v)
+    (setq calls (plus calls 1))
+    v)`
+
+	testExprs(t, prelude, []exprTest{
+		{"or stops at a true operand", "(cons (or (touch false) (touch 7) (touch 8)) (cons calls '()))", "(7 2)"},
+		{"and stops at a false operand", "(cons (and (touch true) (touch false) (touch true)) (cons calls '()))", "(false 2)"},
+		{"operands that are not booleans", `(or null (and 1 "deciding"))`, `"deciding"`},
+		{"empty and", "(and)", "true"},
+		{"empty or", "(or)", "false"},
+		{"when runs its body", "(setq d 0) (when (greater 7 5) (setq d (plus d 1)) (setq d (plus d 10))) d", "11"},
+		{"unless runs its body", "(unless (positive-head '()) 100)", "100"},
+		{"when skips its body", "(when (positive-head '(-1)) 1000)", "null"},
+	})
+}
+
+func TestMacros(t *testing.T) {
+	prelude := `
+(defmacro my-unless (test &rest body)
+    (list 'if test null (cons 'let (cons '() body))))
+
+(func list (&rest xs) xs)
+
+(define-syntax swap
+    (syntax-rules ()
+        ((_ a b) (let ((tmp a)) (setq a b) (setq b tmp)))))
+
+(define-syntax my-or
+    (syntax-rules ()
+        ((_) false)
+        ((_ e) e)
+        ((_ e rest ...) (let ((t e)) (if t t (my-or rest ...))))))
+
+(define-syntax for
+    (syntax-rules (in)
+        ((_ x in xs body ...)
+            (let ((items xs))
+                (while (not (isnull (head items)))
+                    (let ((x (head items))) body ...)
+                    (setq items (tail items)))))))`
+
+	testExprs(t, prelude, []exprTest{
+		{"defmacro", "(setq hits 0) (my-unless (greater hits 0) (setq hits 1)) hits", "1"},
+		{"syntax-rules renames its bindings", "(setq tmp 1) (setq other 2) (swap tmp other) (list tmp other)", "(2 1)"},
+		{"literals and ellipsis", "(setq total 0) (for n in '(1 2 3 4) (setq total (plus total n))) total", "10"},
+		{"recursive syntax-rules", "(setq t true) (my-or false false t)", "true"},
+		{"recursive syntax-rules without a match", "(my-or false false)", "false"},
+		{"macroexpand-1", "(eval (macroexpand-1 '(my-unless false 7)))", "7"},
+	})
+}
+
+func TestQuasiquote(t *testing.T) {
+	prelude := `
+(setq x 2)
+(setq ys '(3 4))
+
+(defmacro swap (a b)
+    (setq tmp (gensym "tmp"))
+    ` + "`" + `(let ((,tmp ,a)) (setq ,a ,b) (setq ,b ,tmp)))
+
+(defmacro my-when (test &rest body)
+    ` + "`" + `(if ,test (let () ,@body) null))`
+
+	testExprs(t, prelude, []exprTest{
+		{"unquote and splicing", "`(1 ,x ,@ys ,@'())", "(1 2 3 4)"},
+		{"nested lists", "`((5 ,(plus x 4)) x)", "((5 6) x)"},
+		{"nested quasiquote", "`(a `(b ,(c ,x)))", "(a `(b ,(c 2)))"},
+		{"template with gensym", "(setq p 10) (setq q 20) (swap p q) (cons p (cons q '()))", "(20 10)"},
+		{"template with splicing", "(setq p 20) (setq q 10) (my-when (greater p q) (setq p (plus p 1)) (setq q 0)) (cons p (cons q '()))", "(21 0)"},
+	})
+}
+
+func TestScopes(t *testing.T) {
+	prelude := `
+(func shadow (f)
+    (func f () 1)
+    (f))
+
+(func outer (x)
+    (let* ((get (lambda () x)))
+        (func x () 20)
+        (get)))
+
+(func opt (a &optional (b (times a 2)))
+    (plus a b))
+
+(func next (n)
+    (eval '(plus n 1)))
+
+(setq show (prog () k))
+(func caller (k)
+    (show))`
+
+	testExprs(t, prelude, []exprTest{
+		{"func replaces a parameter", "(shadow 0)", "1"},
+		{"closure sees a later definition", "((outer 5))", "20"},
+		{"let* rebinds a name", "(let* ((x 1) (y (plus x 1)) (x (times y 100))) (plus x y))", "202"},
+		{"default of an optional parameter", "(opt 3)", "9"},
+		{"eval sees local variables", "(next 9999)", "10000"},
+		{"prog sees the variables of its caller", "(caller 7)", "7"},
+	})
+}
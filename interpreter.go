@@ -0,0 +1,174 @@
+// Package flylang embeds the flylang interpreter into Go programs.
+package flylang
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/flychario/flylang/ast"
+	"github.com/flychario/flylang/macro"
+	"github.com/flychario/flylang/parser"
+	"github.com/flychario/flylang/vm"
+)
+
+// Value is a flylang value.
+type Value = ast.Element
+
+// SyntaxError is returned for programs that can not be parsed.
+type SyntaxError struct {
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Message
+}
+
+// Interpreter evaluates flylang programs. Definitions made by one
+// evaluation are visible to the following ones.
+type Interpreter struct {
+	context    *ast.Context
+	expander   *macro.Expander
+	machine    *vm.VM // runs programs if they are compiled
+	sourceName string
+}
+
+type Option func(*Interpreter)
+
+// WithSourceName sets the file name reported in positions of programs
+// evaluated by EvalString.
+func WithSourceName(name string) Option {
+	return func(in *Interpreter) {
+		in.sourceName = name
+	}
+}
+
+// WithStrictBooleans makes and, or, when, unless and the tests of cond, if
+// and while raise a type mismatch for values that are not booleans instead
+// of counting false and null as false and all other values as true.
+func WithStrictBooleans() Option {
+	return func(in *Interpreter) {
+		in.context.Runtime.StrictBooleans = true
+	}
+}
+
+// WithRandomSeed seeds the generator of random and random-int, so a program
+// gets the same numbers on every run. Otherwise the seed is the current time.
+func WithRandomSeed(seed int64) Option {
+	return func(in *Interpreter) {
+		in.context.Runtime.Random.Seed(seed)
+	}
+}
+
+// WithVM makes the interpreter compile programs to bytecode and run them on
+// a virtual machine instead of evaluating their syntax trees.
+func WithVM() Option {
+	return func(in *Interpreter) {
+		in.machine = vm.New(in.context)
+	}
+}
+
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{context: ast.GetGlobalContext(), sourceName: "<string>"}
+	in.expander = macro.NewExpander(in.context)
+	for _, opt := range opts {
+		opt(in)
+	}
+	return in
+}
+
+// EvalString evaluates a program and returns the value of its last element.
+// The macros in each top-level element are expanded and its variables
+// resolved right before it is evaluated.
+func (in *Interpreter) EvalString(src string) (Value, error) {
+	return in.eval(in.sourceName, []byte(src))
+}
+
+// EvalFile evaluates the program in a file and returns the value of its
+// last element.
+func (in *Interpreter) EvalFile(path string) (Value, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return in.eval(path, content)
+}
+
+func (in *Interpreter) eval(fileName string, src []byte) (res Value, err error) {
+	defer func() {
+		if r := recover(); r != nil { // Syntax errors are reported by panic
+			res, err = nil, &SyntaxError{Message: fmt.Sprint(r)}
+		}
+	}()
+
+	var p parser.Parser
+	p.Init(fileName, src)
+	program := p.ParseProgram()
+
+	return in.call(func() (Value, error) {
+		if in.machine != nil {
+			return in.machine.Eval(program, in.expander.Expand)
+		}
+		return program.EvalEach(in.context, in.prepare)
+	})
+}
+
+// prepare expands the macros of a top-level element and resolves its
+// variables for the evaluator.
+func (in *Interpreter) prepare(e ast.Element) (ast.Element, error) {
+	e, err := in.expander.Expand(e)
+	if err != nil {
+		return nil, err
+	}
+	return ast.Resolve(e), nil
+}
+
+// Define binds a global name to a value.
+func (in *Interpreter) Define(name string, value Value) {
+	in.context.Values[name] = value
+}
+
+// Lookup returns the value bound to a global name.
+func (in *Interpreter) Lookup(name string) (Value, bool) {
+	value := in.context.Get(name)
+	return value, value != nil
+}
+
+// Names returns the sorted global names defined by programs and Define.
+// Builtins are not included.
+func (in *Interpreter) Names() []string {
+	var names []string
+	for name, value := range in.context.Values {
+		if _, ok := value.(*ast.Builtin); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Call calls the function bound to a global name with args.
+func (in *Interpreter) Call(name string, args ...Value) (Value, error) {
+	value, ok := in.Lookup(name)
+	if !ok {
+		return nil, ast.NewRuntimeError(ast.ErrorUndefinedVariable, "%s", name)
+	}
+	fun, ok := value.(ast.Callable)
+	if !ok {
+		return nil, ast.NewRuntimeError(ast.ErrorTypeMismatch, "%s is not a function: %v", name, value)
+	}
+	return in.call(func() (Value, error) {
+		return fun.Call(in.context, args)
+	})
+}
+
+// call runs f and turns a panic escaping from it into an error, so a
+// faulty program can not crash the host.
+func (in *Interpreter) call(f func() (Value, error)) (res Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, ast.NewRuntimeError(ast.ErrorRuntime, "%v", r)
+		}
+	}()
+	return f()
+}
@@ -0,0 +1,270 @@
+package flylang
+
+import (
+	"fmt"
+	"github.com/flychario/flylang/ast"
+	"github.com/flychario/flylang/parser"
+	"testing"
+)
+
+// engines are the options of the interpreters the tests run on: the
+// evaluator and the virtual machine.
+var engines = [][]Option{nil, {WithVM()}}
+
+func TestSamples(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf(fmt.Sprintf("%v", r))
+		}
+	}()
+
+	for _, sample := range []struct {
+		programFile string
+		evalResult  ast.Element
+	}{
+		{"samples/break.fly", ast.LiteralInteger{Value: 3}},
+		{"samples/break_return.fly", ast.LiteralInteger{Value: 3}},
+		{"samples/eval.fly", ast.LiteralInteger{Value: 15}},
+		{"samples/lists.fly", ast.LiteralInteger{Value: 2}},
+		{"samples/logical_operators.fly", ast.LiteralBoolean{Value: true}},
+		{"samples/quote.fly", ast.LiteralInteger{Value: 11}},
+		{"samples/return.fly", ast.LiteralInteger{Value: 5}},
+		{"samples/while.fly", ast.LiteralInteger{Value: 0}},
+
+		{"tests/fib.fly", ast.LiteralInteger{Value: 55}},
+		{"tests/lambda.fly", ast.LiteralInteger{Value: -3}},
+		{"tests/logical-operators.fly", ast.LiteralBoolean{Value: false}},
+		{"tests/closures.fly", ast.LiteralInteger{Value: 18}},
+		{"tests/comments.fly", ast.LiteralInteger{Value: 9}},
+		{"tests/tail-calls.fly", ast.LiteralInteger{Value: 1000000}},
+		{"tests/strings.fly", ast.LiteralString{Value: "HELLO; 42 is positive; 7; 3; \"quoted\"\n"}},
+		{"tests/lists.fly", ast.ListElement{Elements: []ast.Element{ast.LiteralInteger{Value: 1}}}},
+		{"tests/variadic.fly", ast.LiteralInteger{Value: 47}},
+	} {
+		for _, opts := range engines {
+			elem, err := runProgram(sample.programFile, opts...)
+			if err != nil {
+				t.Errorf("sample %s: %v", sample.programFile, err)
+			} else if !ast.Equal(elem, sample.evalResult) {
+				t.Errorf("sample %s: expected %v, got %v", sample.programFile, sample.evalResult, elem)
+			}
+		}
+	}
+}
+
+// datum parses a value written as it prints.
+func datum(src string) ast.Element {
+	var p parser.Parser
+	p.Init("datum", []byte("'"+src))
+	return p.ParseProgram().Elements[0].(ast.Quote).Element
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		program string
+		err     string
+	}{
+		{"(plus x 1)", "test:1:7: undefined variable: x"},
+		{"(1 2)", "test:1:1: type mismatch: The first element of a list must be a function"},
+		{"(func f (a) a) (f 1 2)", "test:1:16: arity mismatch: too many arguments in lambda call"},
+		{"(divide 1 0)", "test:1:1: division by zero: Can't divide 1 by zero"},
+		{"(divide 1/2 0)", "test:1:1: division by zero: Can't divide 1/2 by zero"},
+		{"(inexact->exact (divide 1.0 0))", "test:1:1: type mismatch: Can't make +inf.0 exact"},
+		{"(mod 5 0)", "test:1:1: division by zero: Can't divide 5 by zero"},
+		{"(quotient 123456789012345678901234567890 0)", "test:1:1: division by zero: Can't divide 123456789012345678901234567890 by zero"},
+		{"(expt 0 -1)", "test:1:1: division by zero: Can't raise 0 to the negative power -1"},
+		{"(expt 2 1000000000000)", "test:1:1: runtime error: expt: 2 to the power 1000000000000 would have more than 16777216 bits"},
+		{"(expt 1/3 -100000000)", "test:1:1: runtime error: expt: 1/3 to the power -100000000 would have more than 16777216 bits"},
+		{"(gcd 1 2.0)", "test:1:1: type mismatch: gcd expects an integer, got 2.0"},
+		{"(shift 1 2147483647)", "test:1:1: runtime error: shift: 1 shifted by 2147483647 bits would have more than 16777216 bits"},
+		{"(random-seed 18446744073709551658)", "test:1:1: runtime error: random-seed: can't seed with 18446744073709551658, it does not fit in 64 bits"},
+		{"(random-int 0)", "test:1:1: type mismatch: random-int expects a positive integer, got 0"},
+		{"(rem 1/2 1)", "test:1:1: type mismatch: rem expects integers or reals, got 1/2"},
+		{"(sqrt \"4\")", "test:1:1: type mismatch: sqrt expects a number, got \"4\""},
+		{`(get '(1) "a")`, "test:1:1: type mismatch: get expects a map, got (1)"},
+		{"(setq k '(1)) {k 2}", "test:1:15: type mismatch: Can't use (1) as a map key"},
+		{"(put {} (lambda () 1) 2)", "test:1:1: type mismatch: Can't use (lambda () 1) as a map key"},
+		{"(vector-ref #(1 2) 2)", "test:1:1: runtime error: vector-ref index 2 out of bounds for a vector of length 2"},
+		{"(subvector #(1 2) 1 3)", "test:1:1: runtime error: subvector range 1:3 out of bounds for a vector of length 2"},
+		{"(func zeros () '#(0 0)) (vector-set (zeros) 0 1)", "test:1:25: runtime error: vector-set: can't change the constant vector #(0 0)"},
+		{"(vector-length '(1 2))", "test:1:1: type mismatch: vector-length expects a vector, got (1 2)"},
+		{"(func f () (break)) (while true (f))", "test:1:33: runtime error: break outside of while"},
+		{"(func f (a &optional b) a) (f)", "test:1:28: arity mismatch: not enough arguments in lambda call"},
+		{"(func f (&rest a b) a) (f)", "test:1:18: syntax error: expected ) after the parameter of &rest"},
+		{"(func f (a &rest b &optional c) 1)", "test:1:20: syntax error: expected ) after the parameter of &rest"},
+		{"(func g (&rest) 1)", "test:1:15: syntax error: expected a parameter after &rest"},
+		{"(func k (a a) a)", "test:1:12: syntax error: duplicate parameter a"},
+		{"(func h (a &optional (b)) b)", "test:1:24: syntax error: expected the default of optional parameter b"},
+		{"(lambda (a (b 1)) a)", "test:1:12: syntax error: only parameters after &optional have defaults"},
+		{"(eval '(lambda (x &optional y &optional z) x))", "test:1:31: syntax error: &optional given twice"},
+		{"(let ((a 1) (b a)) b)", "test:1:16: undefined variable: a"},
+		{"`(1 ,@2)", "test:1:5: type mismatch: Can't splice 2, it is not a list"},
+		{"(minus)", "test:1:1: arity mismatch: Not enough arguments to minus: 0 < 1"},
+	} {
+		for _, opts := range engines {
+			_, err := runSource("test", []byte(test.program), opts...)
+			if err == nil {
+				t.Errorf("program %s: expected error %q", test.program, test.err)
+			} else if err.Error() != test.err {
+				t.Errorf("program %s: expected error %q, got %q", test.program, test.err, err)
+			}
+		}
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	src := `(func half (n)
+    (divide n 0))
+(func quarter (n)
+    (plus (half n) 1))
+(quarter 8)`
+	want := `Traceback (most recent call last):
+  test.fly:5:1 in quarter
+  test.fly:4:11 in half
+  test.fly:2:5 in divide
+test.fly:2:5: division by zero: Can't divide 8 by zero`
+
+	for _, opts := range engines {
+		_, err := runSource("test.fly", []byte(src), opts...)
+		rerr, ok := err.(*ast.RuntimeError)
+		if !ok {
+			t.Fatalf("expected runtime error, got %v", err)
+		}
+		if rerr.Kind != ast.ErrorDivisionByZero {
+			t.Errorf("expected %s, got %s", ast.ErrorDivisionByZero, rerr.Kind)
+		}
+		if rerr.Traceback() != want {
+			t.Errorf("expected traceback\n%s\ngot\n%s", want, rerr.Traceback())
+		}
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	in := New()
+	in.Define("limit", ast.LiteralInteger{Value: 10})
+	if _, err := in.EvalString("(func clamp (n) (cond (greater n limit) limit n))"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := in.Call("clamp", ast.LiteralInteger{Value: 42})
+	if err != nil || !ast.Equal(res, ast.LiteralInteger{Value: 10}) {
+		t.Errorf("clamp 42: expected 10, got %v %v", res, err)
+	}
+	res, err = in.EvalString("(clamp 7)")
+	if err != nil || !ast.Equal(res, ast.LiteralInteger{Value: 7}) {
+		t.Errorf("(clamp 7): expected 7, got %v %v", res, err)
+	}
+	if _, ok := in.Lookup("clamp"); !ok {
+		t.Errorf("clamp is not defined")
+	}
+	if _, ok := in.Lookup("missing"); ok {
+		t.Errorf("missing is defined")
+	}
+	if names := in.Names(); len(names) != 2 || names[0] != "clamp" || names[1] != "limit" {
+		t.Errorf("expected names [clamp limit], got %v", names)
+	}
+
+	if _, err := in.Call("limit"); err == nil {
+		t.Errorf("calling a non-function should fail")
+	}
+	if _, err := in.Call("clamp"); err == nil {
+		t.Errorf("calling with missing arguments should fail")
+	}
+	if _, err := in.EvalString("(clamp"); err == nil {
+		t.Errorf("syntax errors should be returned")
+	} else if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("expected syntax error, got %T", err)
+	}
+
+	want := "test.fly:2:7: syntax error: expected IDENTIFIER"
+	if _, err := New(WithSourceName("test.fly")).EvalString("(plus 1\n (setq))"); err == nil || err.Error() != want {
+		t.Errorf("expected syntax error %q, got %v", want, err)
+	}
+}
+
+func TestStrictBooleans(t *testing.T) {
+	for _, test := range []struct {
+		program string
+		value   ast.Element
+		err     string
+	}{
+		{"(or false 1)", ast.LiteralInteger{Value: 1}, "test:1:11: type mismatch: Can't use logical operator on 1"},
+		{"(if 1 2)", ast.LiteralInteger{Value: 2}, "test:1:5: type mismatch: if test evaluated to non-boolean 1"},
+		{"(if null 1 2)", ast.LiteralInteger{Value: 2}, "test:1:5: type mismatch: if test evaluated to non-boolean null"},
+		{"(cond ((less 2 1) 1) (2 3))", ast.LiteralInteger{Value: 3}, "test:1:23: type mismatch: cond test evaluated to non-boolean 2"},
+		{"(setq n 1) (while n (setq n null)) n", ast.LiteralNull{}, "test:1:19: type mismatch: while test evaluated to non-boolean 1"},
+	} {
+		for _, opts := range engines {
+			res, err := runSource("test", []byte(test.program), opts...)
+			if err != nil || !ast.Equal(res, test.value) {
+				t.Errorf("%s: expected %v, got %v %v", test.program, test.value, res, err)
+			}
+			_, err = runSource("test", []byte(test.program), append(opts, WithStrictBooleans())...)
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error %q, got %v", test.program, test.err, err)
+			}
+		}
+	}
+	for _, opts := range engines {
+		if res, err := New(append(opts, WithStrictBooleans())...).EvalString("(and true (or false true))"); err != nil || !ast.Equal(res, ast.LiteralBoolean{Value: true}) {
+			t.Errorf("expected true, got %v %v", res, err)
+		}
+	}
+}
+
+func TestRandomSeed(t *testing.T) {
+	src := "`(,(random) ,(random-int 1000000) ,(random-int 123456789012345678901234567890))"
+	first, err := New(WithRandomSeed(42)).EvalString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range engines {
+		res, err := New(append(opts, WithRandomSeed(42))...).EvalString(src)
+		if err != nil || !ast.Equal(res, first) {
+			t.Errorf("expected %v with the same seed, got %v %v", first, res, err)
+		}
+	}
+
+	// random-seed restarts the sequence
+	res, err := New().EvalString("(random-seed 42) " + src)
+	if err != nil || !ast.Equal(res, first) {
+		t.Errorf("expected %v after random-seed, got %v %v", first, res, err)
+	}
+
+	res, err = New().EvalString("(setq n 0) (while (less n 100) (setq x (random-int 3)) (cond (or (less x 0) (greater x 2)) (return x)) (setq n (plus n 1))) (random)")
+	if r, ok := res.(ast.LiteralReal); err != nil || !ok || r.Value < 0 || r.Value >= 1 {
+		t.Errorf("expected random numbers in range, got %v %v", res, err)
+	}
+}
+
+func runProgram(programFile string, opts ...Option) (ast.Element, error) {
+	return New(opts...).EvalFile(programFile)
+}
+
+func runSource(fileName string, content []byte, opts ...Option) (ast.Element, error) {
+	return New(append(opts, WithSourceName(fileName))...).EvalString(string(content))
+}
+
+// exprTest is a named expression with the datum it evaluates to.
+type exprTest struct {
+	name string
+	expr string
+	want string
+}
+
+// testExprs evaluates each expression after the definitions of prelude on
+// both engines, in an interpreter of its own.
+func testExprs(t *testing.T, prelude string, tests []exprTest) {
+	t.Helper()
+	for _, test := range tests {
+		want := datum(test.want)
+		for _, opts := range engines {
+			res, err := runSource("test", []byte(prelude+"\n"+test.expr), opts...)
+			if err != nil {
+				t.Errorf("%s: %s: %v", test.name, test.expr, err)
+			} else if !ast.Equal(res, want) {
+				t.Errorf("%s: %s: expected %v, got %v", test.name, test.expr, want, res)
+			}
+		}
+	}
+}
@@ -0,0 +1,331 @@
+// Package macro expands the macros of parsed programs before they are
+// evaluated.
+//
+// Macros are defined by defmacro, whose body computes the expansion of a call
+// from its unevaluated arguments, or by define-syntax with syntax-rules,
+// which rewrites calls matching a pattern into a template. Expansions are
+// data, which are parsed again as code, so a macro can expand into special
+// forms like (if ...) or (let ...).
+package macro
+
+import (
+	"fmt"
+
+	"github.com/flychario/flylang/ast"
+	"github.com/flychario/flylang/parser"
+)
+
+// maxDepth limits nested expansions, so a macro expanding into a call of
+// itself fails instead of exhausting the stack.
+const maxDepth = 1000
+
+// A transformer computes the expansion of a macro call.
+type transformer interface {
+	transform(x *Expander, call ast.ListElement) (ast.Element, error)
+}
+
+// procedure is a macro defined by defmacro.
+type procedure struct {
+	fun *ast.Lambda
+}
+
+func (m procedure) transform(x *Expander, call ast.ListElement) (ast.Element, error) {
+	return m.fun.Call(x.context, call.Elements[1:])
+}
+
+// Expander expands the macros of elements. Macro definitions it meets are
+// kept for the elements expanded after them.
+type Expander struct {
+	context *ast.Context // context of defmacro bodies
+	macros  map[string]transformer
+	symbols int // number of symbols made by gensym
+	depth   int // number of expansions in progress
+}
+
+// NewExpander returns an expander that evaluates defmacro bodies in c and
+// defines the builtins gensym, macroexpand and macroexpand-1 in c.
+func NewExpander(c *ast.Context) *Expander {
+	x := &Expander{context: c, macros: make(map[string]transformer)}
+	for _, b := range x.builtins() {
+		c.Add(b.Name, b)
+	}
+	return x
+}
+
+// Expand returns e with all macro calls in it replaced by their expansions
+// and macro definitions replaced by the quoted macro name.
+func (x *Expander) Expand(e ast.Element) (ast.Element, error) {
+	var err error
+	switch e := e.(type) {
+	case ast.ListElement:
+		if m, ok := x.lookup(e); ok {
+			expansion, err := x.expand1(m, e)
+			if err != nil {
+				return nil, err
+			}
+			x.depth++
+			defer func() { x.depth-- }()
+			if x.depth > maxDepth {
+				return nil, x.errorAt(e, "macro expansion of %s is too deep", e.Elements[0])
+			}
+			return x.Expand(expansion)
+		}
+		e.Elements, err = x.expandAll(e.Elements)
+		return e, err
+	case ast.MapElement:
+		e.Elements, err = x.expandAll(e.Elements)
+		return e, err
+	case ast.VectorElement:
+		e.Elements, err = x.expandAll(e.Elements)
+		return e, err
+	case ast.Program:
+		return x.expandProgram(e)
+	case ast.Quasiquote:
+		e.Element, err = x.expandTemplate(e.Element, 1)
+		return e, err
+	case ast.Setq:
+		e.Element, err = x.Expand(e.Element)
+		return e, err
+	case ast.Func:
+		e.SubProg, err = x.expandProgram(e.SubProg)
+		return e, err
+	case ast.Lambda:
+		e.SubProg, err = x.expandProgram(e.SubProg)
+		return e, err
+	case ast.Prog:
+		e.SubProg, err = x.expandProgram(e.SubProg)
+		return e, err
+	case ast.Cond:
+		clauses := make([]ast.Clause, len(e.Clauses))
+		for i, clause := range e.Clauses {
+			if clause.Test, err = x.Expand(clause.Test); err != nil {
+				return nil, err
+			}
+			if clause.Body, err = x.expandProgram(clause.Body); err != nil {
+				return nil, err
+			}
+			clauses[i] = clause
+		}
+		e.Clauses = clauses
+		return e, nil
+	case ast.If:
+		if e.Test, err = x.Expand(e.Test); err != nil {
+			return nil, err
+		}
+		if e.Then, err = x.Expand(e.Then); err != nil {
+			return nil, err
+		}
+		e.Else, err = x.Expand(e.Else)
+		return e, err
+	case ast.Let:
+		bindings := make([]ast.Binding, len(e.Bindings))
+		for i, b := range e.Bindings {
+			if b.Value, err = x.Expand(b.Value); err != nil {
+				return nil, err
+			}
+			bindings[i] = b
+		}
+		e.Bindings = bindings
+		e.Body, err = x.expandProgram(e.Body)
+		return e, err
+	case ast.Logical:
+		e.Elements, err = x.expandAll(e.Elements)
+		return e, err
+	case ast.When:
+		if e.Test, err = x.Expand(e.Test); err != nil {
+			return nil, err
+		}
+		e.Body, err = x.expandProgram(e.Body)
+		return e, err
+	case ast.While:
+		if e.Element1, err = x.Expand(e.Element1); err != nil {
+			return nil, err
+		}
+		e.Element2, err = x.expandProgram(e.Element2)
+		return e, err
+	case ast.Return:
+		e.Element, err = x.Expand(e.Element)
+		return e, err
+	case ast.DefMacro:
+		body, err := x.expandProgram(e.SubProg)
+		if err != nil {
+			return nil, err
+		}
+		fun := &ast.Lambda{Span: e.Span, List: e.List, SubProg: body, Context: x.context}
+		x.macros[e.Atom.Name] = procedure{fun}
+		return ast.Quote{Span: e.Span, Element: e.Atom}, nil
+	case ast.DefineSyntax:
+		x.macros[e.Atom.Name] = newRules(e)
+		return ast.Quote{Span: e.Span, Element: e.Atom}, nil
+	}
+	return e, nil
+}
+
+// expandTemplate expands the macros in the elements of a quasiquote template
+// that are evaluated, the ones unquoted at depth 1. The rest of the template
+// is data.
+func (x *Expander) expandTemplate(e ast.Element, depth int) (ast.Element, error) {
+	var err error
+	switch e := e.(type) {
+	case ast.ListElement:
+		e.Elements, err = x.expandTemplates(e.Elements, depth)
+		return e, err
+	case ast.MapElement:
+		e.Elements, err = x.expandTemplates(e.Elements, depth)
+		return e, err
+	case ast.VectorElement:
+		e.Elements, err = x.expandTemplates(e.Elements, depth)
+		return e, err
+	case ast.Quasiquote:
+		e.Element, err = x.expandTemplate(e.Element, depth+1)
+		return e, err
+	case ast.Unquote:
+		e.Element, err = x.expandUnquoted(e.Element, depth)
+		return e, err
+	case ast.UnquoteSplicing:
+		e.Element, err = x.expandUnquoted(e.Element, depth)
+		return e, err
+	}
+	return e, nil
+}
+
+func (x *Expander) expandTemplates(elements []ast.Element, depth int) ([]ast.Element, error) {
+	expanded := make([]ast.Element, len(elements))
+	for i, elem := range elements {
+		var err error
+		if expanded[i], err = x.expandTemplate(elem, depth); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+func (x *Expander) expandUnquoted(e ast.Element, depth int) (ast.Element, error) {
+	if depth == 1 {
+		return x.Expand(e)
+	}
+	return x.expandTemplate(e, depth-1)
+}
+
+func (x *Expander) expandAll(elements []ast.Element) ([]ast.Element, error) {
+	expanded := make([]ast.Element, len(elements))
+	for i, elem := range elements {
+		var err error
+		if expanded[i], err = x.Expand(elem); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+func (x *Expander) expandProgram(p ast.Program) (ast.Program, error) {
+	var err error
+	p.Elements, err = x.expandAll(p.Elements)
+	return p, err
+}
+
+// lookup returns the macro called by e, if any.
+func (x *Expander) lookup(e ast.ListElement) (transformer, bool) {
+	if len(e.Elements) == 0 {
+		return nil, false
+	}
+	name, ok := e.Elements[0].(ast.Atom)
+	if !ok {
+		return nil, false
+	}
+	m, ok := x.macros[name.Name]
+	return m, ok
+}
+
+// expand1 expands the call of the macro m once and parses the expansion as
+// code. The macro gets its arguments as data, with the special forms in them
+// turned back into lists.
+func (x *Expander) expand1(m transformer, call ast.ListElement) (ast.Element, error) {
+	name := call.Elements[0].(ast.Atom).Name
+	expansion, err := m.transform(x, parser.Unparse(call).(ast.ListElement))
+	if err != nil {
+		if rerr, ok := err.(*ast.RuntimeError); ok {
+			if !rerr.Pos.IsValid() {
+				rerr.Pos = call.Pos()
+			}
+			rerr.Stack = append(rerr.Stack, ast.Frame{Function: name, Pos: call.Pos()})
+		}
+		return nil, err
+	}
+	code, err := parser.Reparse(expansion)
+	if err != nil {
+		return nil, x.errorAt(call, "expansion of %s: %v", name, err)
+	}
+	return code, nil
+}
+
+// gensym returns a new symbol starting with prefix. Symbols contain a # and
+// can not clash with names written in a program.
+func (x *Expander) gensym(prefix string) ast.Atom {
+	x.symbols++
+	return ast.Atom{Name: fmt.Sprintf("%s#%d", prefix, x.symbols)}
+}
+
+func (x *Expander) errorAt(e ast.Element, format string, args ...interface{}) *ast.RuntimeError {
+	err := ast.NewRuntimeError(ast.ErrorSyntax, format, args...)
+	err.Pos = e.Pos()
+	return err
+}
+
+func (x *Expander) builtins() []ast.Builtin {
+	return []ast.Builtin{
+		{
+			Name:     "gensym",
+			Args:     []ast.Element{ast.Atom{Name: "prefix"}},
+			Variadic: true,
+			Code: func(c *ast.Context, args []ast.Element) (ast.Element, error) {
+				switch len(args) {
+				case 0:
+					return x.gensym("g"), nil
+				case 1:
+					if prefix, ok := args[0].(ast.LiteralString); ok {
+						return x.gensym(prefix.Value), nil
+					}
+					return nil, ast.NewRuntimeError(ast.ErrorTypeMismatch, "gensym: expected string, got %v", args[0])
+				}
+				return nil, ast.NewRuntimeError(ast.ErrorArityMismatch, "Wrong number of arguments to gensym: %d != 1", len(args))
+			},
+		},
+		{
+			Name: "macroexpand-1",
+			Args: []ast.Element{ast.Atom{Name: "form"}},
+			Code: func(c *ast.Context, args []ast.Element) (ast.Element, error) {
+				if call, ok := args[0].(ast.ListElement); ok {
+					if m, ok := x.lookup(call); ok {
+						return x.expand1(m, call)
+					}
+				}
+				return args[0], nil
+			},
+		},
+		{
+			Name: "macroexpand",
+			Args: []ast.Element{ast.Atom{Name: "form"}},
+			Code: func(c *ast.Context, args []ast.Element) (ast.Element, error) {
+				form := args[0]
+				for depth := 0; ; depth++ {
+					call, ok := form.(ast.ListElement)
+					if !ok {
+						return form, nil
+					}
+					m, ok := x.lookup(call)
+					if !ok {
+						return form, nil
+					}
+					if depth == maxDepth {
+						return nil, x.errorAt(call, "macro expansion of %s is too deep", call.Elements[0])
+					}
+					var err error
+					if form, err = x.expand1(m, call); err != nil {
+						return nil, err
+					}
+				}
+			},
+		},
+	}
+}
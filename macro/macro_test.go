@@ -0,0 +1,133 @@
+package macro
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flychario/flylang/ast"
+	"github.com/flychario/flylang/parser"
+)
+
+func expand(t *testing.T, src string) (string, error) {
+	t.Helper()
+	var p parser.Parser
+	p.Init("test", []byte(src))
+	program := p.ParseProgram()
+
+	c := ast.GetGlobalContext()
+	x := NewExpander(c)
+	var res ast.Element
+	for i, e := range program.Elements {
+		expanded, err := x.Expand(e)
+		if err != nil {
+			return "", err
+		}
+		// The last element is only expanded
+		if i < len(program.Elements)-1 {
+			if _, err := expanded.Eval(c); err != nil {
+				return "", err
+			}
+		}
+		res = expanded
+	}
+	return ast.Print(res), nil
+}
+
+func TestExpand(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  string
+	}{
+		{
+			"(defmacro twice (e) (list 'prog '() e e)) (func list (&rest xs) xs) (twice (f 1))",
+			"(prog () (f 1) (f 1))",
+		},
+		{
+			"(func list (&rest xs) xs) (defmacro inc (x) (list 'setq x (list 'plus x 1))) (lambda (a) (inc a))",
+			"(lambda (a) (setq a (plus a 1)))",
+		},
+		{
+			"(define-syntax swap (syntax-rules () ((_ a b) (let ((tmp a)) (setq a b) (setq b tmp))))) (swap tmp y)",
+			"(let ((tmp#1 tmp)) (setq tmp y) (setq y tmp#1))",
+		},
+		{
+			"(define-syntax my-list (syntax-rules () ((_ (a b) ...) (list (plus a b) ...)))) (my-list (1 2) (3 4))",
+			"(list (plus 1 2) (plus 3 4))",
+		},
+		{
+			"(define-syntax my-and (syntax-rules () ((_) true) ((_ e) e) ((_ e r ...) (if e (my-and r ...) false)))) (my-and a b c)",
+			"(if a (if b c false) false)",
+		},
+		{
+			"(define-syntax is (syntax-rules (not) ((_ not e) (not e)) ((_ e) e))) (is not x)",
+			"(not x)",
+		},
+		{
+			"(define-syntax m (syntax-rules () ((_ (f x ...) ...) '((x ...) ...)))) (m (f 1 2) (g) (h 3))",
+			"'((1 2) () (3))",
+		},
+		// macros get special forms in their arguments as lists
+		{
+			"(defmacro first-of (form) (cons 'quote (cons (head form) '()))) (first-of (if a b c))",
+			"'if",
+		},
+		{
+			"(defmacro test-of (form) (head (tail form))) (test-of (when (less a b) (f a)))",
+			"(less a b)",
+		},
+		{
+			"(defmacro body-of (form) (head (tail (tail form)))) (body-of (lambda (x &optional (y 'z)) (setq x y)))",
+			"(setq x y)",
+		},
+		{
+			"(defmacro quoted (form) (cons 'quote (cons (head (tail form)) '()))) (quoted 'x)",
+			"'x",
+		},
+		{
+			"(define-syntax then-of (syntax-rules (if) ((_ (if test then else)) then))) (then-of (if a (let ((b 1)) b) c))",
+			"(let ((b 1)) b)",
+		},
+		{
+			"(define-syntax clauses (syntax-rules (cond) ((_ (cond (test body) ...)) '(test ...)))) (clauses (cond ((less a b) 1) (else 2)))",
+			"'((less a b) else)",
+		},
+	} {
+		res, err := expand(t, test.input)
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+		} else if res != test.want {
+			t.Errorf("%s: expected %s, got %s", test.input, test.want, res)
+		}
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		err   string
+	}{
+		{
+			"(define-syntax m (syntax-rules () ((_ a) a))) (m 1 2)",
+			"test:1:47: syntax error: no syntax rule of m matches (m 1 2)",
+		},
+		{
+			"(define-syntax m (syntax-rules () ((_ a ...) a))) (m 1 2)",
+			"test:1:51: syntax error: m: pattern variable a used without ...",
+		},
+		{
+			"(defmacro m () '(m)) (m)",
+			"test:1:17: syntax error: macro expansion of m is too deep",
+		},
+		{
+			"(func list (&rest xs) xs) (defmacro m () (list 'setq 1 2)) (m)",
+			"test:1:60: syntax error: expansion of m: test:1:54: syntax error: expected IDENTIFIER",
+		},
+	} {
+		_, err := expand(t, test.input)
+		if err == nil {
+			t.Errorf("%s: expected error %q", test.input, test.err)
+		} else if !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: expected error %q, got %q", test.input, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package macro
+
+import "github.com/flychario/flylang/ast"
+
+const ellipsis = "..."
+
+// rules is a macro defined by syntax-rules. The first rule whose pattern
+// matches a call gives the expansion.
+//
+// Names bound in a template by let, let*, letrec, lambda, func and prog are
+// renamed to new symbols in every expansion, so they can not capture the
+// variables of the code passed to the macro.
+type rules struct {
+	name     string
+	literals map[string]bool
+	rules    []ast.SyntaxRule
+}
+
+func newRules(d ast.DefineSyntax) rules {
+	m := rules{name: d.Atom.Name, literals: make(map[string]bool), rules: d.Rules}
+	for _, l := range d.Literals {
+		m.literals[l.Name] = true
+	}
+	return m
+}
+
+// binding is the input matched by a pattern variable. A variable under an
+// ellipsis is bound to the bindings of each repetition.
+type binding struct {
+	element  ast.Element
+	repeated bool
+	items    []*binding
+}
+
+type bindings map[string]*binding
+
+func (m rules) transform(x *Expander, call ast.ListElement) (ast.Element, error) {
+	for _, rule := range m.rules {
+		// The head of a pattern stands for the macro name and is not matched
+		pattern := rule.Pattern.(ast.ListElement)
+		b := bindings{}
+		if !m.match(ast.ListElement{Elements: pattern.Elements[1:]}, ast.ListElement{Elements: call.Elements[1:]}, b) {
+			continue
+		}
+
+		renames := make(map[string]string)
+		for _, name := range binders(rule.Template, nil) {
+			if _, ok := b[name]; !ok && name != ellipsis {
+				renames[name] = x.gensym(name).Name
+			}
+		}
+		return m.instantiate(rule.Template, b, renames)
+	}
+	return nil, x.errorAt(call, "no syntax rule of %s matches %v", m.name, call)
+}
+
+// match reports whether input matches pattern and binds the pattern
+// variables in b.
+func (m rules) match(pattern, input ast.Element, b bindings) bool {
+	switch p := pattern.(type) {
+	case ast.Atom:
+		if p.Name == "_" {
+			return true
+		}
+		if m.literals[p.Name] {
+			atom, ok := input.(ast.Atom)
+			return ok && atom.Name == p.Name
+		}
+		b[p.Name] = &binding{element: input}
+		return true
+	case ast.ListElement:
+		in, ok := input.(ast.ListElement)
+		if !ok {
+			return false
+		}
+		k := ellipsisIndex(p.Elements)
+		if k < 0 {
+			if len(p.Elements) != len(in.Elements) {
+				return false
+			}
+			for i := range p.Elements {
+				if !m.match(p.Elements[i], in.Elements[i], b) {
+					return false
+				}
+			}
+			return true
+		}
+
+		// p ... matches any number of elements between the patterns before
+		// and after it
+		before, repeated, after := p.Elements[:k-1], p.Elements[k-1], p.Elements[k+1:]
+		if len(in.Elements) < len(before)+len(after) {
+			return false
+		}
+		for i := range before {
+			if !m.match(before[i], in.Elements[i], b) {
+				return false
+			}
+		}
+		rest := in.Elements[len(in.Elements)-len(after):]
+		for i := range after {
+			if !m.match(after[i], rest[i], b) {
+				return false
+			}
+		}
+
+		vars := m.variables(repeated, nil)
+		for _, v := range vars {
+			b[v] = &binding{repeated: true}
+		}
+		for _, elem := range in.Elements[len(before) : len(in.Elements)-len(after)] {
+			ib := bindings{}
+			if !m.match(repeated, elem, ib) {
+				return false
+			}
+			for _, v := range vars {
+				b[v].items = append(b[v].items, ib[v])
+			}
+		}
+		return true
+	}
+	return ast.Equal(pattern, input)
+}
+
+// variables appends the pattern variables of pattern to vars.
+func (m rules) variables(pattern ast.Element, vars []string) []string {
+	switch p := pattern.(type) {
+	case ast.Atom:
+		if p.Name != "_" && p.Name != ellipsis && !m.literals[p.Name] {
+			vars = append(vars, p.Name)
+		}
+	case ast.ListElement:
+		for _, elem := range p.Elements {
+			vars = m.variables(elem, vars)
+		}
+	}
+	return vars
+}
+
+// instantiate replaces the pattern variables in template by their bindings
+// and the names in renames by their new names.
+func (m rules) instantiate(template ast.Element, b bindings, renames map[string]string) (ast.Element, error) {
+	switch t := template.(type) {
+	case ast.Atom:
+		if bd, ok := b[t.Name]; ok {
+			if bd.repeated {
+				return nil, ast.NewRuntimeError(ast.ErrorSyntax, "%s: pattern variable %s used without %s", m.name, t.Name, ellipsis)
+			}
+			return bd.element, nil
+		}
+		if name, ok := renames[t.Name]; ok {
+			return ast.Atom{Span: t.Span, Name: name}, nil
+		}
+		return t, nil
+	case ast.ListElement:
+		var elements []ast.Element
+		for i := 0; i < len(t.Elements); i++ {
+			if i+1 < len(t.Elements) && isEllipsis(t.Elements[i+1]) {
+				items, err := m.repeat(t.Elements[i], b, renames)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, items...)
+				i++
+				continue
+			}
+			elem, err := m.instantiate(t.Elements[i], b, renames)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, elem)
+		}
+		return ast.ListElement{Span: t.Span, Elements: elements}, nil
+	}
+	return template, nil
+}
+
+// repeat instantiates a template followed by an ellipsis once for every
+// repetition of the pattern variables under the ellipsis in it.
+func (m rules) repeat(template ast.Element, b bindings, renames map[string]string) ([]ast.Element, error) {
+	n := -1
+	var vars []string
+	for _, v := range m.variables(template, nil) {
+		if bd, ok := b[v]; ok && bd.repeated {
+			if n >= 0 && len(bd.items) != n {
+				return nil, ast.NewRuntimeError(ast.ErrorSyntax, "%s: pattern variables under %s repeat a different number of times", m.name, ellipsis)
+			}
+			n = len(bd.items)
+			vars = append(vars, v)
+		}
+	}
+	if n < 0 {
+		return nil, ast.NewRuntimeError(ast.ErrorSyntax, "%s: no pattern variable repeats under %s", m.name, ellipsis)
+	}
+
+	elements := make([]ast.Element, n)
+	for i := range elements {
+		ib := make(bindings, len(b))
+		for name, bd := range b {
+			ib[name] = bd
+		}
+		for _, v := range vars {
+			ib[v] = b[v].items[i]
+		}
+		var err error
+		if elements[i], err = m.instantiate(template, ib, renames); err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+// binders appends the names bound by let, let*, letrec, lambda, func and
+// prog forms in template to names.
+func binders(template ast.Element, names []string) []string {
+	list, ok := template.(ast.ListElement)
+	if !ok {
+		return names
+	}
+	if len(list.Elements) >= 2 {
+		head, _ := list.Elements[0].(ast.Atom)
+		switch head.Name {
+		case "let", "let*", "letrec":
+			// the names of bindings (name value)
+			if bs, ok := list.Elements[1].(ast.ListElement); ok {
+				for _, b := range bs.Elements {
+					if b, ok := b.(ast.ListElement); ok && len(b.Elements) > 0 {
+						names = appendName(names, b.Elements[0])
+					}
+				}
+			}
+		case "lambda", "prog", "func":
+			// the parameters, func defines a global name, which is kept
+			params := list.Elements[1]
+			if head.Name == "func" && len(list.Elements) >= 3 {
+				params = list.Elements[2]
+			}
+			if ps, ok := params.(ast.ListElement); ok {
+				for _, p := range ps.Elements {
+					if p, ok := p.(ast.ListElement); ok && len(p.Elements) > 0 {
+						names = appendName(names, p.Elements[0]) // (name default)
+					} else {
+						names = appendName(names, p)
+					}
+				}
+			}
+		}
+	}
+	for _, elem := range list.Elements {
+		names = binders(elem, names)
+	}
+	return names
+}
+
+func appendName(names []string, e ast.Element) []string {
+	if atom, ok := e.(ast.Atom); ok && atom.Name != "&optional" && atom.Name != "&rest" {
+		names = append(names, atom.Name)
+	}
+	return names
+}
+
+func ellipsisIndex(elements []ast.Element) int {
+	for i := 1; i < len(elements); i++ {
+		if isEllipsis(elements[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isEllipsis(e ast.Element) bool {
+	atom, ok := e.(ast.Atom)
+	return ok && atom.Name == ellipsis
+}
@@ -0,0 +1,146 @@
+package flylang
+
+import (
+	"testing"
+
+	"github.com/flychario/flylang/ast"
+)
+
+func TestNumbers(t *testing.T) {
+	prelude := `
+(setq inf (divide 1.0 0))
+(setq nan (minus inf inf))`
+
+	testExprs(t, prelude, []exprTest{
+		{"quotient", "(quotient 17 5)", "3"},
+		{"quotient truncates", "(quotient -17 5)", "-3"},
+		{"rem takes the sign of the dividend", "(rem -17 5)", "-2"},
+		{"mod takes the sign of the divisor", "(mod -17 5)", "3"},
+		{"mod by a negative divisor", "(mod 17 -5)", "-3"},
+		{"mod of reals", "(mod 5.5 2)", "1.5"},
+		{"quotient of reals", "(quotient 7.0 2)", "3.0"},
+		{"abs", "(abs -7)", "7"},
+		{"abs of the smallest int64", "(abs -9223372036854775808)", "9223372036854775808"},
+		{"abs of a rational", "(abs -1/2)", "1/2"},
+		{"abs of a real", "(abs -2.5)", "2.5"},
+		{"min", "(min 3 1/2 2)", "1/2"},
+		{"max", "(max 3 1/2 2)", "3"},
+		{"min with a real", "(min 1 2.0)", "1.0"},
+		{"floor", "(floor 7/2)", "3"},
+		{"ceil", "(ceil 7/2)", "4"},
+		{"round half up to even", "(round 7/2)", "4"},
+		{"round half down to even", "(round 5/2)", "2"},
+		{"round negative half to even", "(round -5/2)", "-2"},
+		{"truncate", "(truncate -7/2)", "-3"},
+		{"floor of a real", "(floor -3.5)", "-4.0"},
+		{"round of a real", "(round 2.5)", "2.0"},
+		{"expt grows past 64 bits", "(expt 2 100)", "1267650600228229401496703205376"},
+		{"expt of a rational", "(expt 2/3 -2)", "9/4"},
+		{"expt to a real power", "(expt 2 0.5)", "1.4142135623730951"},
+		{"expt of a real", "(expt 4.0 2)", "16.0"},
+		{"sqrt of a square", "(sqrt 16)", "4"},
+		{"sqrt of a rational square", "(sqrt 9/4)", "3/2"},
+		{"sqrt", "(sqrt 2)", "1.4142135623730951"},
+		{"sqrt of a negative number", "(isnan (sqrt -1))", "true"},
+		{"isinf", "(isinf inf)", "true"},
+		{"isnan", "(isnan nan)", "true"},
+		{"isnan of an integer", "(isnan 1)", "false"},
+		{"nan is not equal to itself", "(equal nan nan)", "false"},
+		{"+inf.0", "(equal inf +inf.0)", "true"},
+		{"-inf.0", "(less -inf.0 -1)", "true"},
+		{"+nan.0", "(isnan +nan.0)", "true"},
+		{"number->string of -inf.0", "(number->string (minus inf))", `"-inf.0"`},
+	})
+}
+
+func TestRationals(t *testing.T) {
+	prelude := `
+(func harmonic (n)
+    (let ((sum 0))
+        (while (greater n 0)
+            (setq sum (plus sum (divide 1 n)))
+            (setq n (minus n 1)))
+        sum))`
+
+	testExprs(t, prelude, []exprTest{
+		{"divide", "(divide 1 3)", "1/3"},
+		{"plus to an integer", "(plus 1/3 2/3)", "1"},
+		{"times to an integer", "(times 6/4 2)", "3"},
+		{"minus", "(minus -1/2 1)", "-3/2"},
+		{"harmonic", "(harmonic 10)", "7381/2520"},
+		{"plus with a real", "(plus 1/2 0.25)", "0.75"},
+		{"less with a real", "(less 1/3 0.34 1/2)", "true"},
+		{"equal after reducing", "(equal 2/4 1/2)", "true"},
+		{"divide by a rational", "(divide 1 3 1/3)", "1"},
+		{"exact->inexact", "(exact->inexact 3/8)", "0.375"},
+		{"inexact->exact", "(inexact->exact 0.125)", "1/8"},
+		{"inexact->exact of an integer", "(inexact->exact 5)", "5"},
+		{"divide a bignum", "(divide 123456789012345678901234567890 -10)", "-12345678901234567890123456789"},
+	})
+}
+
+func TestMath(t *testing.T) {
+	prelude := `
+(func close (a b)
+    (less (abs (minus a b)) 0.000000001))`
+
+	testExprs(t, prelude, []exprTest{
+		{"sin", "(close (sin (divide (pi) 2)) 1)", "true"},
+		{"cos", "(close (cos (pi)) -1)", "true"},
+		{"tan", "(close (tan 0) 0)", "true"},
+		{"atan2", "(close (atan2 1 1) (divide (pi) 4))", "true"},
+		{"log of exp", "(close (log (exp 2)) 2)", "true"},
+		{"log of e", "(close (log (e)) 1)", "true"},
+		{"log10", "(log10 1000)", "3.0"},
+		{"pow", "(pow 2 10)", "1024.0"},
+		{"gcd", "(gcd 12 -18 30)", "6"},
+		{"gcd of nothing", "(gcd)", "0"},
+		{"lcm", "(lcm 4 6 10)", "60"},
+		{"lcm with zero", "(lcm 0 5)", "0"},
+		{"gcd of a bignum", "(gcd 123456789012345678901234567890 30)", "30"},
+		{"bitand", "(bitand 12 10)", "8"},
+		{"bitor", "(bitor 12 10)", "14"},
+		{"bitxor", "(bitxor 12 10)", "6"},
+		{"bitand of a negative number", "(bitand -1 255)", "255"},
+		{"shift past 64 bits", "(shift 1 70)", "1180591620717411303424"},
+		{"shift right of a negative number", "(shift -16 -2)", "-4"},
+		{"shift right", "(shift 5 -1)", "2"},
+	})
+}
+
+func TestBignums(t *testing.T) {
+	prelude := `
+(func fact (n)
+    (cond (equal n 0) 1 (times n (fact (minus n 1)))))
+
+(func fib (n)
+    (let ((a 0) (b 1))
+        (while (greater n 0)
+            (setq b (plus a b))
+            (setq a (minus b a))
+            (setq n (minus n 1)))
+        a))
+
+(setq big 123456789012345678901234567890)`
+
+	testExprs(t, prelude, []exprTest{
+		{"fact", "(fact 30)", "265252859812191058636308480000000"},
+		{"fib", "(fib 100)", "354224848179261915075"},
+		{"divide to a small integer", "(divide (fact 30) (fact 28))", "870"},
+		{"minus to a small integer", "(minus (plus big 1) big)", "1"},
+		{"plus overflows", "(plus 9223372036854775807 1)", "9223372036854775808"},
+		{"times overflows", "(times -1 -9223372036854775808)", "9223372036854775808"},
+		{"less with a bignum", "(less 9223372036854775807 big 1000000000000000000000000000000.0)", "true"},
+		{"equal of bignums", "(equal (times big 2) (plus big big))", "true"},
+	})
+
+	for _, opts := range engines {
+		res, err := runSource("test", []byte(prelude+"\n(minus (plus big 1) big)"), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := res.(ast.LiteralInteger); !ok {
+			t.Errorf("expected a small integer, got %T", res)
+		}
+	}
+}
@@ -5,27 +5,93 @@ import (
 	"github.com/flychario/flylang/ast"
 	"github.com/flychario/flylang/scanner"
 	"github.com/flychario/flylang/token"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 // The Parser structure holds the Parser's internal state.
 type Parser struct {
-	scanner scanner.Scanner
+	scanner  scanner.Scanner
+	filename string
 
 	// Next token
 	pos token.Position // token position
+	end token.Position // position after the token
 	tok token.Token    // one token look-ahead
 	lit string         // token literal
+
+	prevEnd token.Position // position after the last consumed token
+
+	quasiquotes int // depth of nested quasiquote templates being parsed
+	quotes      int // depth of nested quotes being parsed
+
+	// Tokens of an element given to Reparse, read instead of the scanner
+	items []item
+	value ast.Element // element of a VALUE token
+}
+
+// An item is a token made from an element by Reparse.
+type item struct {
+	pos, end token.Position
+	tok      token.Token
+	lit      string
+	value    ast.Element
 }
 
 func (p *Parser) Init(filename string, src []byte) {
 	p.scanner.Init(src)
+	p.filename = filename
 
 	p.next()
 }
 
 func (p *Parser) next() {
+	p.prevEnd = p.end
+	p.scan()
+
+	// #; comments out the element following it
+	for p.tok == token.DATUM_COMMENT {
+		prevEnd := p.prevEnd
+		p.next()
+		p.parseElement()
+		p.prevEnd = prevEnd
+	}
+}
+
+func (p *Parser) scan() {
+	if p.items != nil {
+		it := p.items[0]
+		if len(p.items) > 1 { // the last item is EOF
+			p.items = p.items[1:]
+		}
+		p.pos, p.end, p.tok, p.lit, p.value = it.pos, it.end, it.tok, it.lit, it.value
+		return
+	}
 	p.pos, p.tok, p.lit = p.scanner.Scan()
+	p.end = p.scanner.Pos()
+	p.pos.Filename = p.filename
+	p.end.Filename = p.filename
+}
+
+// tokenSpan returns the source range of the current token.
+func (p *Parser) tokenSpan() ast.Span {
+	return ast.Span{StartPos: p.pos, EndPos: p.end}
+}
+
+// span returns the source range from start to the end of the last consumed
+// token.
+func (p *Parser) span(start token.Position) ast.Span {
+	if p.prevEnd.Offset < start.Offset {
+		return ast.Span{StartPos: start, EndPos: start}
+	}
+	return ast.Span{StartPos: start, EndPos: p.prevEnd}
+}
+
+// listSpan returns the source range of a list form opened at start. The
+// closing parenthesis is expected to be the current token.
+func (p *Parser) listSpan(start token.Position) ast.Span {
+	return ast.Span{StartPos: start, EndPos: p.end}
 }
 
 func (p *Parser) expect(tok token.Token) {
@@ -37,66 +103,144 @@ func (p *Parser) expect(tok token.Token) {
 
 func (p *Parser) parseList() ast.List {
 	var elements []ast.Element
+	start := p.pos
 	p.expect(token.LPAREN)
-	defer p.expect(token.RPAREN)
 
+	var form ast.List
 	switch p.tok {
 	case token.SETQ:
-		return p.parseSetq()
+		form = p.parseSetq(start)
 	case token.FUNC:
-		return p.parseFunc()
+		form = p.parseFunc(start)
 	case token.LAMBDA:
-		return p.parseLambda()
+		form = p.parseLambda(start)
 	case token.PROG:
-		return p.parseProg()
+		form = p.parseProg(start)
 	case token.COND:
-		return p.parseCond()
+		form = p.parseCond(start)
+	case token.IF:
+		form = p.parseIf(start)
+	case token.LET, token.LET_STAR, token.LETREC:
+		form = p.parseLet(start)
+	case token.AND, token.OR:
+		form = p.parseLogical(start)
+	case token.WHEN, token.UNLESS:
+		form = p.parseWhen(start)
+	case token.DEFMACRO:
+		form = p.parseDefMacro(start)
+	case token.DEFINE_SYNTAX:
+		form = p.parseDefineSyntax(start)
 	case token.QUOTE:
-		return p.parseQuote()
+		form = p.parseQuote(start)
 	case token.WHILE:
-		return p.parseWhile()
+		form = p.parseWhile(start)
 	case token.RETURN:
-		return p.parseReturn()
+		form = p.parseReturn(start)
 	case token.BREAK:
-		return p.parseBreak()
+		form = p.parseBreak(start)
+	}
+	if form != nil {
+		p.expect(token.RPAREN)
+		return form
 	}
 
 	for p.tok != token.RPAREN {
 		elements = append(elements, p.parseElement())
 	}
 
-	return ast.ListElement{Elements: elements}
+	list := ast.ListElement{Span: p.listSpan(start), Elements: elements}
+	p.expect(token.RPAREN)
+	return list
+}
+
+// parseMap parses a map literal {key value ...}, with its elements parsed by
+// parse.
+func (p *Parser) parseMap(parse func() ast.Element) ast.MapElement {
+	var elements []ast.Element
+	start := p.pos
+	p.expect(token.LBRACE)
+	for p.tok != token.RBRACE {
+		elements = append(elements, parse())
+	}
+	if len(elements)%2 != 0 {
+		p.ThrowError("expected value of map key")
+	}
+	span := p.listSpan(start)
+	p.next()
+	return ast.MapElement{Span: span, Elements: elements}
+}
+
+// parseVector parses a vector literal #(element ...), with its elements
+// parsed by parse.
+func (p *Parser) parseVector(parse func() ast.Element) ast.VectorElement {
+	var elements []ast.Element
+	start := p.pos
+	p.expect(token.VECTOR)
+	for p.tok != token.RPAREN {
+		elements = append(elements, parse())
+	}
+	span := p.listSpan(start)
+	p.next()
+	return ast.VectorElement{Span: span, Elements: elements}
+}
+
+// mapValue returns the map of a map literal read as data, as in '{a 1}.
+func (p *Parser) mapValue(m ast.MapElement) ast.Map {
+	res, err := ast.NewMap(m.Span, m.Elements)
+	if err != nil {
+		p.ThrowError(err.(*ast.RuntimeError).Message)
+	}
+	return res
 }
 
 func (p *Parser) parseLiteral() (ret ast.Literal) {
 	if p.tok == token.INTEGER {
-		val, err := strconv.ParseInt(p.lit, 10, 64)
-		if err != nil {
+		if val, err := strconv.ParseInt(p.lit, 10, 64); err == nil {
+			ret = ast.LiteralInteger{Span: p.tokenSpan(), Value: val}
+		} else if val, ok := new(big.Int).SetString(p.lit, 10); ok {
+			ret = ast.LiteralBigInteger{Span: p.tokenSpan(), Value: val}
+		} else {
 			p.ThrowError(err.Error())
 		}
-		ret = ast.LiteralInteger{Value: val}
 		p.expect(token.INTEGER)
 		return ret
 	} else if p.tok == token.REAL {
-		val, err := strconv.ParseFloat(p.lit, 64)
+		val, err := ast.ParseReal(p.lit)
 		if err != nil {
 			p.ThrowError(err.Error())
 		}
-		ret = ast.LiteralReal{Value: val}
+		ret = ast.LiteralReal{Span: p.tokenSpan(), Value: val}
 		p.expect(token.REAL)
 		return ret
+	} else if p.tok == token.RATIONAL {
+		val, ok := new(big.Rat).SetString(p.lit)
+		if !ok {
+			p.ThrowError("invalid rational literal " + p.lit)
+		}
+		ret = ast.NewRational(p.tokenSpan(), val)
+		p.expect(token.RATIONAL)
+		return ret
 	} else if p.tok == token.BOOLEAN {
 		if p.lit == "true" {
-			ret = ast.LiteralBoolean{Value: true}
+			ret = ast.LiteralBoolean{Span: p.tokenSpan(), Value: true}
 		} else if p.lit == "false" {
-			ret = ast.LiteralBoolean{Value: false}
+			ret = ast.LiteralBoolean{Span: p.tokenSpan(), Value: false}
 		} else {
 			p.ThrowError("invalid boolean literal")
 		}
 		p.expect(token.BOOLEAN)
 		return ret
+	} else if p.tok == token.STRING {
+		// Literal line breaks are allowed in strings, Unquote only accepts escapes
+		val, err := strconv.Unquote(strings.ReplaceAll(p.lit, "\n", `\n`))
+		if err != nil {
+			p.ThrowError("invalid string literal")
+		}
+		ret = ast.LiteralString{Span: p.tokenSpan(), Value: val}
+		p.expect(token.STRING)
+		return ret
 	} else if p.tok == token.NULL {
-		ret = ast.LiteralNull{}
+		ret = ast.LiteralNull{Span: p.tokenSpan()}
 		p.expect(token.NULL)
 		return ret
 	}
@@ -105,77 +249,403 @@ func (p *Parser) parseLiteral() (ret ast.Literal) {
 }
 
 func (p *Parser) parseAtom() ast.Atom {
-	ret := ast.Atom{Name: p.lit}
+	ret := ast.Atom{Span: p.tokenSpan(), Name: p.lit}
 	p.expect(token.IDENTIFIER)
 	return ret
 }
 
 func (p *Parser) parseShortQuote() ast.Quote {
+	start := p.pos
 	p.expect(token.SHORT_QUOTE)
-	return ast.Quote{Element: p.parseElement()}
+	p.quotes++
+	elem := p.parseElement()
+	p.quotes--
+	return ast.Quote{Span: p.span(start), Element: elem}
+}
+
+// parseQuasiquote parses `template. The template is read as data, the
+// elements after , and ,@ in it as code.
+func (p *Parser) parseQuasiquote() ast.Quasiquote {
+	start := p.pos
+	p.expect(token.BACKQUOTE)
+	p.quasiquotes++
+	elem := p.parseDatum()
+	p.quasiquotes--
+	return ast.Quasiquote{Span: p.span(start), Element: elem}
 }
 
-func (p *Parser) parseQuote() ast.Quote {
+func (p *Parser) parseQuote(start token.Position) ast.Quote {
 	p.expect(token.QUOTE)
-	return ast.Quote{Element: p.parseElement()}
+	p.quotes++
+	elem := p.parseElement()
+	p.quotes--
+	return ast.Quote{Span: p.listSpan(start), Element: elem}
 }
 
-func (p *Parser) parseSetq() ast.Setq {
+func (p *Parser) parseSetq(start token.Position) ast.Setq {
 	p.expect(token.SETQ)
-	return ast.Setq{Atom: p.parseAtom(), Element: p.parseElement()}
+	atom, elem := p.parseAtom(), p.parseElement()
+	return ast.Setq{Span: p.listSpan(start), Atom: atom, Element: elem}
 }
 
-func (p *Parser) parseFunc() ast.Func {
+func (p *Parser) parseFunc(start token.Position) ast.Func {
 	p.expect(token.FUNC)
-	return ast.Func{Atom: p.parseAtom(), List: p.parseList(), SubProg: p.ParseSubProgram()}
+	atom, list, subProg := p.parseAtom(), p.parseParams(), p.ParseSubProgram()
+	return ast.Func{Span: p.listSpan(start), Atom: atom, List: list, SubProg: subProg}
 }
 
-func (p *Parser) parseLambda() ast.Lambda {
+func (p *Parser) parseLambda(start token.Position) ast.Lambda {
 	p.expect(token.LAMBDA)
-	return ast.Lambda{List: p.parseList(), SubProg: p.ParseSubProgram()}
+	list, subProg := p.parseParams(), p.ParseSubProgram()
+	return ast.Lambda{Span: p.listSpan(start), List: list, SubProg: subProg}
 }
 
-func (p *Parser) parseProg() ast.Prog {
+func (p *Parser) parseProg(start token.Position) ast.Prog {
 	p.expect(token.PROG)
-	return ast.Prog{List: p.parseList(), SubProg: p.ParseSubProgram()}
+	list, subProg := p.parseParams(), p.ParseSubProgram()
+	return ast.Prog{Span: p.listSpan(start), List: list, SubProg: subProg}
+}
+
+// parseParams parses the parameters of a lambda, func, prog or defmacro:
+// names, then optionally &optional followed by names or (name default), then
+// optionally &rest followed by one name.
+func (p *Parser) parseParams() ast.List {
+	start := p.pos
+	p.expect(token.LPAREN)
+	var elements []ast.Element
+	mode := ""
+	names := map[string]bool{}
+	name := func() ast.Atom {
+		if p.tok == token.IDENTIFIER && names[p.lit] {
+			p.ThrowError("duplicate parameter " + p.lit)
+		}
+		atom := p.parseAtom()
+		names[atom.Name] = true
+		return atom
+	}
+	for p.tok != token.RPAREN {
+		switch {
+		case p.tok == token.IDENTIFIER && p.lit == "&optional":
+			if mode != "" {
+				p.ThrowError("&optional given twice")
+			}
+			mode = p.lit
+			elements = append(elements, p.parseAtom())
+		case p.tok == token.IDENTIFIER && p.lit == "&rest":
+			mode = p.lit
+			elements = append(elements, p.parseAtom())
+			if p.tok == token.RPAREN {
+				p.ThrowError("expected a parameter after &rest")
+			}
+			elements = append(elements, name())
+			if p.tok != token.RPAREN {
+				p.ThrowError("expected ) after the parameter of &rest")
+			}
+		case p.tok == token.LPAREN && mode == "&optional":
+			paramStart := p.pos
+			p.next()
+			param := name()
+			if p.tok == token.RPAREN {
+				p.ThrowError("expected the default of optional parameter " + param.Name)
+			}
+			def := p.parseElement()
+			elements = append(elements, ast.ListElement{Span: p.listSpan(paramStart), Elements: []ast.Element{param, def}})
+			p.expect(token.RPAREN)
+		case p.tok == token.LPAREN:
+			p.ThrowError("only parameters after &optional have defaults")
+		default:
+			elements = append(elements, name())
+		}
+	}
+	list := ast.ListElement{Span: p.listSpan(start), Elements: elements}
+	p.next()
+	return list
 }
 
-func (p *Parser) parseCond() ast.Cond {
+// parseCond parses both shapes of cond: the clause form
+// (cond (test body...) ... (else body...)) and the older (cond test then [else]),
+// which is the same as if.
+func (p *Parser) parseCond(start token.Position) ast.List {
 	p.expect(token.COND)
-	list := p.parseList()
-	element1 := p.parseElement()
+	var elements []ast.Element
+	for p.tok != token.RPAREN {
+		elements = append(elements, p.parseElement())
+	}
+
+	if !isClauseForm(elements) {
+		if len(elements) < 2 || len(elements) > 3 {
+			p.ThrowError("expected cond clause")
+		}
+		if _, ok := elements[0].(ast.List); !ok {
+			p.ThrowError("expected list")
+		}
+		cond := ast.If{Span: p.listSpan(start), Test: elements[0], Then: elements[1]}
+		if len(elements) == 3 {
+			cond.Else = elements[2]
+		}
+		return cond
+	}
+
+	clauses := make([]ast.Clause, len(elements))
+	for i, elem := range elements {
+		list, ok := elem.(ast.ListElement)
+		if !ok || len(list.Elements) == 0 {
+			p.ThrowError("expected cond clause")
+		}
+		clause := ast.Clause{Span: list.Span, Test: list.Elements[0]}
+		if isElse(clause.Test) {
+			if i != len(elements)-1 {
+				p.ThrowError("else clause must be the last clause of cond")
+			}
+			clause.Test = nil
+		}
+		body := list.Elements[1:]
+		if len(body) > 0 {
+			clause.Body.Span = ast.Span{StartPos: body[0].Pos(), EndPos: body[len(body)-1].End()}
+		}
+		clause.Body.Elements = body
+		clauses[i] = clause
+	}
+	return ast.Cond{Span: p.listSpan(start), Clauses: clauses}
+}
+
+// isClauseForm reports whether the elements of a cond are clauses. The older
+// form has two or three elements, the first a call or special form, as in
+// (cond (greater a b) (f a) (f b)). Lists starting with a literal or else
+// can't be calls, so with one of them the elements are clauses, as in
+// (cond (flag 1) (true 2)).
+func isClauseForm(elements []ast.Element) bool {
+	if len(elements) != 2 && len(elements) != 3 {
+		return true
+	}
+	switch first := elements[0].(type) {
+	case ast.ListElement:
+		if len(first.Elements) == 0 {
+			return true
+		} else if _, call := first.Elements[0].(ast.Atom); !call {
+			return true
+		}
+	case ast.List: // a special form, like (and a b)
+	default:
+		return true
+	}
+	for _, elem := range elements {
+		if list, ok := elem.(ast.ListElement); ok && len(list.Elements) > 0 {
+			if _, ok := list.Elements[0].(ast.Literal); ok || isElse(list.Elements[0]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isElse(e ast.Element) bool {
+	atom, ok := e.(ast.Atom)
+	return ok && atom.Name == "else"
+}
+
+func (p *Parser) parseIf(start token.Position) ast.If {
+	p.expect(token.IF)
+	test, then := p.parseElement(), p.parseElement()
 	if p.tok == token.RPAREN {
-		return ast.Cond{List: list, Element1: element1, Element2: nil}
+		return ast.If{Span: p.listSpan(start), Test: test, Then: then}
+	}
+	els := p.parseElement()
+	return ast.If{Span: p.listSpan(start), Test: test, Then: then, Else: els}
+}
+
+func (p *Parser) parseLet(start token.Position) ast.Let {
+	kind := p.tok
+	p.expect(kind)
+	p.expect(token.LPAREN)
+	var bindings []ast.Binding
+	for p.tok != token.RPAREN {
+		bindingStart := p.pos
+		p.expect(token.LPAREN)
+		name, value := p.parseAtom(), p.parseElement()
+		bindings = append(bindings, ast.Binding{Span: p.listSpan(bindingStart), Name: name, Value: value})
+		p.expect(token.RPAREN)
+	}
+	p.expect(token.RPAREN)
+	body := p.ParseSubProgram()
+	return ast.Let{Span: p.listSpan(start), Kind: kind, Bindings: bindings, Body: body}
+}
+
+func (p *Parser) parseLogical(start token.Position) ast.Logical {
+	kind := p.tok
+	p.expect(kind)
+	var elements []ast.Element
+	for p.tok != token.RPAREN {
+		elements = append(elements, p.parseElement())
+	}
+	return ast.Logical{Span: p.listSpan(start), Kind: kind, Elements: elements}
+}
+
+func (p *Parser) parseWhen(start token.Position) ast.When {
+	kind := p.tok
+	p.expect(kind)
+	test, body := p.parseElement(), p.ParseSubProgram()
+	return ast.When{Span: p.listSpan(start), Kind: kind, Test: test, Body: body}
+}
+
+func (p *Parser) parseDefMacro(start token.Position) ast.DefMacro {
+	p.expect(token.DEFMACRO)
+	atom, list, subProg := p.parseAtom(), p.parseParams(), p.ParseSubProgram()
+	return ast.DefMacro{Span: p.listSpan(start), Atom: atom, List: list, SubProg: subProg}
+}
+
+// parseDefineSyntax parses
+// (define-syntax name (syntax-rules (literals...) (pattern template)...)).
+func (p *Parser) parseDefineSyntax(start token.Position) ast.DefineSyntax {
+	p.expect(token.DEFINE_SYNTAX)
+	atom := p.parseAtom()
+	p.expect(token.LPAREN)
+	p.expect(token.SYNTAX_RULES)
+
+	var literals []ast.Atom
+	p.expect(token.LPAREN)
+	for p.tok != token.RPAREN {
+		// Keywords can be literals, to match special forms passed to the macro
+		if token.IsKeyword(p.lit) {
+			literals = append(literals, p.parseKeywordAtom())
+		} else {
+			literals = append(literals, p.parseAtom())
+		}
 	}
-	element2 := p.parseElement()
-	return ast.Cond{List: list, Element1: element1, Element2: element2}
+	p.expect(token.RPAREN)
+
+	var rules []ast.SyntaxRule
+	for p.tok != token.RPAREN {
+		ruleStart := p.pos
+		p.expect(token.LPAREN)
+		pattern := p.parseDatum()
+		if list, ok := pattern.(ast.ListElement); !ok || len(list.Elements) == 0 {
+			p.ThrowError("syntax-rules pattern must be a list")
+		}
+		template := p.parseDatum()
+		rules = append(rules, ast.SyntaxRule{Span: p.listSpan(ruleStart), Pattern: pattern, Template: template})
+		p.expect(token.RPAREN)
+	}
+	p.expect(token.RPAREN)
+	return ast.DefineSyntax{Span: p.listSpan(start), Atom: atom, Literals: literals, Rules: rules}
+}
+
+// parseDatum parses an element as data: lists are never special forms and
+// keywords are atoms. 'x is read as (quote x).
+func (p *Parser) parseDatum() ast.Element {
+	start := p.pos
+	switch {
+	case p.tok == token.LPAREN:
+		p.next()
+		var elements []ast.Element
+		for p.tok != token.RPAREN {
+			elements = append(elements, p.parseDatum())
+		}
+		span := p.listSpan(start)
+		p.next()
+		return ast.ListElement{Span: span, Elements: elements}
+	case p.tok == token.LBRACE:
+		m := p.parseMap(p.parseDatum)
+		if p.quasiquotes > 0 { // filled in by the quasiquote
+			return m
+		}
+		return p.mapValue(m)
+	case p.tok == token.VECTOR:
+		v := p.parseVector(p.parseDatum)
+		if p.quasiquotes > 0 {
+			return v
+		}
+		return ast.Vector{Span: v.Span, Elements: v.Elements, Constant: true}
+	case p.tok == token.SHORT_QUOTE:
+		p.next()
+		elem := p.parseDatum()
+		return ast.ListElement{Span: p.span(start), Elements: []ast.Element{ast.Atom{Name: "quote"}, elem}}
+	case p.tok == token.UNQUOTE:
+		p.next()
+		elem := p.parseUnquoted()
+		return ast.Unquote{Span: p.span(start), Element: elem}
+	case p.tok == token.UNQUOTE_SPLICING:
+		p.next()
+		elem := p.parseUnquoted()
+		return ast.UnquoteSplicing{Span: p.span(start), Element: elem}
+	case token.IsKeyword(p.lit):
+		return p.parseKeywordAtom()
+	}
+	return p.parseElement()
+}
+
+// parseUnquoted parses the element after , or ,@. It is code unless it is in
+// a nested quasiquote, where it belongs to the template of the outer one.
+func (p *Parser) parseUnquoted() ast.Element {
+	depth := p.quasiquotes
+	defer func() { p.quasiquotes = depth }()
+	if depth > 1 {
+		p.quasiquotes--
+		return p.parseDatum()
+	}
+	p.quasiquotes = 0
+	return p.parseElement()
+}
+
+// parseKeywordAtom parses a keyword as an atom.
+func (p *Parser) parseKeywordAtom() ast.Atom {
+	ret := ast.Atom{Span: p.tokenSpan(), Name: p.lit}
+	p.next()
+	return ret
 }
 
-func (p *Parser) parseWhile() ast.While {
+func (p *Parser) parseWhile(start token.Position) ast.While {
 	p.expect(token.WHILE)
-	return ast.While{Element1: p.parseElement(), Element2: p.ParseSubProgram()}
+	element1, element2 := p.parseElement(), p.ParseSubProgram()
+	return ast.While{Span: p.listSpan(start), Element1: element1, Element2: element2}
 }
 
-func (p *Parser) parseReturn() ast.Return {
+func (p *Parser) parseReturn(start token.Position) ast.Return {
 	p.expect(token.RETURN)
-	return ast.Return{Element: p.parseElement()}
+	elem := p.parseElement()
+	return ast.Return{Span: p.listSpan(start), Element: elem}
 }
 
-func (p *Parser) parseBreak() ast.Break {
+func (p *Parser) parseBreak(start token.Position) ast.Break {
 	p.expect(token.BREAK)
-	return ast.Break{}
+	return ast.Break{Span: p.listSpan(start)}
 }
 
 func (p *Parser) parseElement() ast.Element {
 	switch p.tok {
 	case token.IDENTIFIER:
 		return p.parseAtom()
-	case token.INTEGER, token.REAL, token.BOOLEAN, token.NULL:
+	case token.INTEGER, token.REAL, token.RATIONAL, token.BOOLEAN, token.NULL, token.STRING:
 		return p.parseLiteral()
 	case token.SHORT_QUOTE:
 		return p.parseShortQuote()
+	case token.BACKQUOTE:
+		return p.parseQuasiquote()
+	case token.UNQUOTE, token.UNQUOTE_SPLICING:
+		p.ThrowError(p.tok.String() + " outside of quasiquote")
 	case token.LPAREN:
 		return p.parseList()
+	case token.LBRACE:
+		m := p.parseMap(p.parseElement)
+		if p.quotes > 0 {
+			return p.mapValue(m)
+		}
+		return m
+	case token.VECTOR:
+		v := p.parseVector(p.parseElement)
+		if p.quotes > 0 {
+			return ast.Vector{Span: v.Span, Elements: v.Elements, Constant: true}
+		}
+		return v
+	case token.VALUE:
+		ret := p.value
+		p.next()
+		return ret
+	}
+
+	// Keywords out of the head of a list are names, as in (list 'if a b)
+	if token.IsKeyword(p.lit) {
+		return p.parseKeywordAtom()
 	}
 
 	p.ThrowError("expected element")
@@ -184,20 +654,69 @@ func (p *Parser) parseElement() ast.Element {
 
 func (p *Parser) ParseSubProgram() ast.Program {
 	var elements []ast.Element
+	start := p.pos
 	for p.tok != token.RPAREN {
 		elements = append(elements, p.parseElement())
 	}
-	return ast.Program{Elements: elements}
+	return ast.Program{Span: p.span(start), Elements: elements}
 }
 
 func (p *Parser) ParseProgram() ast.Program {
 	var elements []ast.Element
+	start := p.pos
 	for p.tok != token.EOF {
 		elements = append(elements, p.parseElement())
 	}
-	return ast.Program{Elements: elements}
+	return ast.Program{Span: p.span(start), Elements: elements}
+}
+
+// Reparse parses an element built at runtime, like the expansion of a macro,
+// as code: lists starting with a keyword become special forms. Other elements
+// than lists and atoms, including special forms, are kept as they are.
+func Reparse(e ast.Element) (res ast.Element, err error) {
+	defer func() {
+		if r := recover(); r != nil { // Syntax errors are reported by panic
+			res, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+
+	var p Parser
+	p.items = append(flatten(e, nil), item{pos: e.End(), end: e.End(), tok: token.EOF})
+	p.next()
+	res = p.parseElement()
+	if p.tok != token.EOF {
+		p.ThrowError("expected end of element")
+	}
+	return res, nil
+}
+
+// flatten appends the tokens of e to items.
+func flatten(e ast.Element, items []item) []item {
+	switch e := e.(type) {
+	case ast.ListElement:
+		items = append(items, item{pos: e.Pos(), end: e.Pos(), tok: token.LPAREN})
+		for _, elem := range e.Elements {
+			items = flatten(elem, items)
+		}
+		return append(items, item{pos: e.End(), end: e.End(), tok: token.RPAREN})
+	case ast.MapElement:
+		items = append(items, item{pos: e.Pos(), end: e.Pos(), tok: token.LBRACE})
+		for _, elem := range e.Elements {
+			items = flatten(elem, items)
+		}
+		return append(items, item{pos: e.End(), end: e.End(), tok: token.RBRACE})
+	case ast.VectorElement:
+		items = append(items, item{pos: e.Pos(), end: e.Pos(), tok: token.VECTOR})
+		for _, elem := range e.Elements {
+			items = flatten(elem, items)
+		}
+		return append(items, item{pos: e.End(), end: e.End(), tok: token.RPAREN})
+	case ast.Atom:
+		return append(items, item{pos: e.Pos(), end: e.End(), tok: token.Lookup(e.Name), lit: e.Name})
+	}
+	return append(items, item{pos: e.Pos(), end: e.End(), tok: token.VALUE, value: e})
 }
 
 func (p *Parser) ThrowError(msg string) {
-	panic(fmt.Sprintf("Syntax error: %s %d:%d", msg, p.pos.Line, p.pos.Column))
+	panic(fmt.Sprintf("%s: syntax error: %s", p.pos, msg))
 }
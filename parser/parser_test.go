@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"github.com/flychario/flylang/ast"
 	"testing"
 )
 
@@ -49,3 +50,198 @@ func TestSetq(t *testing.T) {
 		t.Log(res)
 	}
 }
+
+func TestPositions(t *testing.T) {
+	src := "(setq a 1)\n(lambda (x)\n    (plus x 'y))"
+	var p Parser
+	p.Init("test.fly", []byte(src))
+	prog := p.ParseProgram()
+
+	setq := prog.Elements[0].(ast.Setq)
+	lambda := prog.Elements[1].(ast.Lambda)
+	call := lambda.SubProg.Elements[0].(ast.ListElement)
+	for _, test := range []struct {
+		elem       ast.Element
+		start, end string
+	}{
+		{prog, "test.fly:1:1", "test.fly:3:17"},
+		{setq, "test.fly:1:1", "test.fly:1:11"},
+		{setq.Atom, "test.fly:1:7", "test.fly:1:8"},
+		{setq.Element, "test.fly:1:9", "test.fly:1:10"},
+		{lambda, "test.fly:2:1", "test.fly:3:17"},
+		{lambda.List, "test.fly:2:9", "test.fly:2:12"},
+		{call, "test.fly:3:5", "test.fly:3:16"},
+		{call.Elements[2], "test.fly:3:13", "test.fly:3:15"},
+	} {
+		if test.elem.Pos().String() != test.start || test.elem.End().String() != test.end {
+			t.Errorf("%#v: expected %s-%s, got %s-%s", test.elem, test.start, test.end, test.elem.Pos(), test.elem.End())
+		}
+	}
+}
+
+func TestDatumComment(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  string
+	}{
+		{"#;(a b) c", "c"},
+		{"(a #;b c)", "(a c)"},
+		{"(a #; #;b c d)", "(a d)"},
+		{"(a #;'(b c) d)", "(a d)"},
+	} {
+		var p, w Parser
+		p.Init("test", []byte(test.input))
+		w.Init("want", []byte(test.want))
+		res, want := p.ParseProgram(), w.ParseProgram()
+		if !ast.Equal(res, want) {
+			t.Errorf("%s: expected %#v, got %#v", test.input, want, res)
+		}
+	}
+}
+
+func TestCond(t *testing.T) {
+	for _, test := range []struct {
+		input   string
+		clauses int // -1 for the older form, which parses to if
+	}{
+		{"(cond (less a b) a)", -1},
+		{"(cond (less a b) (f a) (f b))", -1},
+		{"(cond ((less a b) a) ((less b a) b))", 2},
+		{"(cond (x 1) (else 2))", 2},
+		{"(cond (true 1))", 1},
+		{"(cond (flag 1) (true 2))", 2},
+		{"(cond (flag 1))", 1},
+		{"(cond (flag 1) (other 2))", -1},
+		{"(cond (and a b) c)", -1},
+		{"(cond ((less a b) a) (true (f a) b) (else c))", 3},
+		{"(cond)", 0},
+	} {
+		var p Parser
+		p.Init("test", []byte(test.input))
+		switch res := p.ParseProgram().Elements[0].(type) {
+		case ast.Cond:
+			if len(res.Clauses) != test.clauses {
+				t.Errorf("%s: expected %d clauses, got %d", test.input, test.clauses, len(res.Clauses))
+			}
+		case ast.If:
+			if test.clauses != -1 {
+				t.Errorf("%s: expected cond, got %v", test.input, res)
+			}
+		}
+	}
+}
+
+func TestReparse(t *testing.T) {
+	quote := ast.Quote{Element: ast.Atom{Name: "x"}}
+	data := ast.ListElement{Elements: []ast.Element{
+		ast.Atom{Name: "if"},
+		ast.ListElement{Elements: []ast.Element{ast.Atom{Name: "f"}, ast.Atom{Name: "true"}}},
+		quote,
+	}}
+	res, err := Reparse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ast.If{
+		Test: ast.ListElement{Elements: []ast.Element{ast.Atom{Name: "f"}, ast.LiteralBoolean{Value: true}}},
+		Then: quote,
+	}
+	if !ast.Equal(res, want) {
+		t.Errorf("expected %v, got %v", want, res)
+	}
+
+	if _, err := Reparse(ast.ListElement{Elements: []ast.Element{ast.Atom{Name: "setq"}}}); err == nil {
+		t.Errorf("expected syntax error")
+	}
+}
+
+// Unparsing code and parsing the result again gives the same code
+func TestUnparse(t *testing.T) {
+	for _, src := range []string{
+		"(if (less a b) 'a `(b ,c))",
+		"(cond ((less a b) a) (else b))",
+		"(func f (a &optional (b (g a)) &rest c) (let* ((d 1)) (while (and a d) (setq d null)) (return d)))",
+		"(prog () (when a (break)) (unless b {k (f x)} #(1 (g y))))",
+		"(define-syntax m (syntax-rules (if) ((_ (if a b)) b)))",
+	} {
+		var p Parser
+		p.Init("test", []byte(src))
+		code := p.ParseProgram().Elements[0]
+		data := Unparse(code)
+		if _, ok := data.(ast.ListElement); !ok {
+			t.Errorf("%s: expected a list, got %T", src, data)
+			continue
+		}
+		res, err := Reparse(data)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+		} else if ast.Print(res) != ast.Print(code) {
+			t.Errorf("%s: expected %s, got %s", src, ast.Print(code), ast.Print(res))
+		}
+	}
+}
+
+func TestIntegers(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		big   bool
+	}{
+		{"9223372036854775807", false},
+		{"-9223372036854775808", false},
+		{"9223372036854775808", true},
+		{"-123456789012345678901234567890", true},
+	} {
+		var p Parser
+		p.Init("test", []byte(test.input))
+		res := p.ParseProgram().Elements[0]
+		if _, ok := res.(ast.LiteralBigInteger); ok != test.big {
+			t.Errorf("%s: expected big integer %v, got %#v", test.input, test.big, res)
+		}
+		if res.(fmt.Stringer).String() != test.input {
+			t.Errorf("%s: parsed as %v", test.input, res)
+		}
+	}
+}
+
+func TestMaps(t *testing.T) {
+	var p Parser
+	p.Init("test", []byte("{a (f 1)} '{a (f 1) a 2}"))
+	program := p.ParseProgram()
+	if m, ok := program.Elements[0].(ast.MapElement); !ok || len(m.Elements) != 2 {
+		t.Errorf("expected map literal, got %#v", program.Elements[0])
+	}
+	m, ok := program.Elements[1].(ast.Quote).Element.(ast.Map)
+	if !ok || m.Len() != 1 {
+		t.Fatalf("expected quoted map, got %v", program.Elements[1])
+	}
+	if v, _ := m.Get(ast.Atom{Name: "a"}); !ast.Equal(v, ast.LiteralInteger{Value: 2}) {
+		t.Errorf("expected the last value of a, got %v", v)
+	}
+
+	for _, src := range []string{"{a}", "'{(a) 1}", "{a 1"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected syntax error", src)
+				}
+			}()
+			p.Init("test", []byte(src))
+			p.ParseProgram()
+		}()
+	}
+}
+
+func TestVectors(t *testing.T) {
+	var p Parser
+	p.Init("test", []byte("#(a (f 1)) '#(a (f 1)) `#(a ,b)"))
+	program := p.ParseProgram()
+	if v, ok := program.Elements[0].(ast.VectorElement); !ok || len(v.Elements) != 2 {
+		t.Errorf("expected vector literal, got %#v", program.Elements[0])
+	}
+	if v, ok := program.Elements[1].(ast.Quote).Element.(ast.Vector); !ok || len(v.Elements) != 2 {
+		t.Errorf("expected quoted vector, got %v", program.Elements[1])
+	}
+	if _, ok := program.Elements[2].(ast.Quasiquote).Element.(ast.VectorElement); !ok {
+		t.Errorf("expected vector template, got %v", program.Elements[2])
+	}
+}
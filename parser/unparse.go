@@ -0,0 +1,109 @@
+package parser
+
+import "github.com/flychario/flylang/ast"
+
+// Unparse returns parsed code as data, the inverse of Reparse: special forms
+// become lists starting with their keyword and 'x becomes (quote x). The
+// lists keep the positions of the forms.
+func Unparse(e ast.Element) ast.Element {
+	switch e := e.(type) {
+	case ast.ListElement:
+		return ast.ListElement{Span: e.Span, Elements: unparseAll(e.Elements)}
+	case ast.MapElement:
+		return ast.MapElement{Span: e.Span, Elements: unparseAll(e.Elements)}
+	case ast.VectorElement:
+		return ast.VectorElement{Span: e.Span, Elements: unparseAll(e.Elements)}
+	case ast.Quote:
+		return form(e, "quote", Unparse(e.Element))
+	case ast.Setq:
+		return form(e, "setq", e.Atom, Unparse(e.Element))
+	case ast.Func:
+		return form(e, "func", append([]ast.Element{e.Atom, unparseParams(e.List)}, unparseAll(e.SubProg.Elements)...)...)
+	case ast.Lambda:
+		return form(e, "lambda", append([]ast.Element{unparseParams(e.List)}, unparseAll(e.SubProg.Elements)...)...)
+	case ast.Prog:
+		return form(e, "prog", append([]ast.Element{unparseParams(e.List)}, unparseAll(e.SubProg.Elements)...)...)
+	case ast.DefMacro:
+		return form(e, "defmacro", append([]ast.Element{e.Atom, unparseParams(e.List)}, unparseAll(e.SubProg.Elements)...)...)
+	case ast.Cond:
+		clauses := make([]ast.Element, len(e.Clauses))
+		for i, clause := range e.Clauses {
+			var test ast.Element = ast.Atom{Span: ast.Span{StartPos: clause.Pos(), EndPos: clause.Pos()}, Name: "else"}
+			if clause.Test != nil {
+				test = Unparse(clause.Test)
+			}
+			clauses[i] = ast.ListElement{Span: clause.Span, Elements: append([]ast.Element{test}, unparseAll(clause.Body.Elements)...)}
+		}
+		return form(e, "cond", clauses...)
+	case ast.If:
+		if e.Else == nil {
+			return form(e, "if", Unparse(e.Test), Unparse(e.Then))
+		}
+		return form(e, "if", Unparse(e.Test), Unparse(e.Then), Unparse(e.Else))
+	case ast.Let:
+		bindings := make([]ast.Element, len(e.Bindings))
+		for i, b := range e.Bindings {
+			bindings[i] = ast.ListElement{Span: b.Span, Elements: []ast.Element{b.Name, Unparse(b.Value)}}
+		}
+		list := ast.ListElement{Span: ast.Span{StartPos: e.Pos(), EndPos: e.Pos()}, Elements: bindings}
+		return form(e, e.Kind.String(), append([]ast.Element{list}, unparseAll(e.Body.Elements)...)...)
+	case ast.Logical:
+		return form(e, e.Kind.String(), unparseAll(e.Elements)...)
+	case ast.When:
+		return form(e, e.Kind.String(), append([]ast.Element{Unparse(e.Test)}, unparseAll(e.Body.Elements)...)...)
+	case ast.While:
+		return form(e, "while", append([]ast.Element{Unparse(e.Element1)}, unparseAll(e.Element2.Elements)...)...)
+	case ast.Return:
+		return form(e, "return", Unparse(e.Element))
+	case ast.Break:
+		return form(e, "break")
+	case ast.DefineSyntax:
+		literals := make([]ast.Element, len(e.Literals))
+		for i, l := range e.Literals {
+			literals[i] = l
+		}
+		rules := []ast.Element{keyword(e, "syntax-rules"), ast.ListElement{Elements: literals}}
+		for _, r := range e.Rules {
+			rules = append(rules, ast.ListElement{Span: r.Span, Elements: []ast.Element{r.Pattern, r.Template}})
+		}
+		return form(e, "define-syntax", e.Atom, ast.ListElement{Span: e.Span, Elements: rules})
+	}
+	return e
+}
+
+func unparseAll(elements []ast.Element) []ast.Element {
+	data := make([]ast.Element, len(elements))
+	for i, e := range elements {
+		data[i] = Unparse(e)
+	}
+	return data
+}
+
+// unparseParams returns a parameter list with the defaults of its optional
+// parameters as data.
+func unparseParams(params ast.List) ast.Element {
+	list, ok := params.(ast.ListElement)
+	if !ok {
+		return params
+	}
+	elements := make([]ast.Element, len(list.Elements))
+	for i, param := range list.Elements {
+		if p, ok := param.(ast.ListElement); ok {
+			param = ast.ListElement{Span: p.Span, Elements: []ast.Element{p.Elements[0], Unparse(p.Elements[1])}}
+		}
+		elements[i] = param
+	}
+	return ast.ListElement{Span: list.Span, Elements: elements}
+}
+
+// form returns the list of the special form e, its keyword name followed by
+// elements.
+func form(e ast.Element, name string, elements ...ast.Element) ast.ListElement {
+	span := ast.Span{StartPos: e.Pos(), EndPos: e.End()}
+	return ast.ListElement{Span: span, Elements: append([]ast.Element{keyword(e, name)}, elements...)}
+}
+
+// keyword returns the atom name placed at the start of e.
+func keyword(e ast.Element, name string) ast.Atom {
+	return ast.Atom{Span: ast.Span{StartPos: e.Pos(), EndPos: e.Pos()}, Name: name}
+}
@@ -0,0 +1,187 @@
+package flylang
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/flychario/flylang/ast"
+)
+
+var (
+	valueType = reflect.TypeOf((*Value)(nil)).Elem()
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterFunc defines a builtin that calls the Go function fn. Parameters
+// and results may be integers, floats, bools, strings, slices of those, or
+// Values, which are passed unchanged. fn may be variadic and may return an
+// error as its last result, which is raised as a runtime error.
+func (in *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	b, err := newFuncBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+	in.Define(name, b)
+	return nil
+}
+
+func newFuncBuiltin(name string, fn interface{}) (*ast.Builtin, error) {
+	fv := reflect.ValueOf(fn)
+	if !fv.IsValid() || fv.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s: expected a function, got %T", name, fn)
+	}
+	ft := fv.Type()
+
+	for i := 0; i < ft.NumIn(); i++ {
+		t := ft.In(i)
+		if ft.IsVariadic() && i == ft.NumIn()-1 {
+			t = t.Elem()
+		}
+		if !isConvertible(t) {
+			return nil, fmt.Errorf("%s: unsupported parameter type %s", name, t)
+		}
+	}
+
+	returnsError := ft.NumOut() > 0 && ft.Out(ft.NumOut()-1) == errorType
+	results := ft.NumOut()
+	if returnsError {
+		results--
+	}
+	if results > 1 || (results == 1 && !isConvertible(ft.Out(0))) {
+		return nil, fmt.Errorf("%s: unsupported result types of %s", name, ft)
+	}
+
+	args := make([]ast.Element, ft.NumIn())
+	for i := range args {
+		args[i] = ast.Atom{Name: fmt.Sprintf("arg%d", i+1)}
+	}
+
+	code := func(c *ast.Context, args []ast.Element) (ast.Element, error) {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var t reflect.Type
+			if ft.IsVariadic() && i >= ft.NumIn()-1 {
+				t = ft.In(ft.NumIn() - 1).Elem()
+			} else {
+				t = ft.In(i)
+			}
+			v, err := fromValue(arg, t)
+			if err != nil {
+				return nil, ast.NewRuntimeError(ast.ErrorTypeMismatch, "%s: argument %d: %v", name, i+1, err)
+			}
+			in[i] = v
+		}
+
+		out := fv.Call(in)
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				rerr := ast.NewRuntimeError(ast.ErrorRuntime, "%s: %v", name, err)
+				rerr.Err = err
+				return nil, rerr
+			}
+		}
+		if results == 0 {
+			return ast.LiteralNull{}, nil
+		}
+		return toValue(out[0])
+	}
+
+	return &ast.Builtin{Name: name, Args: args, Variadic: ft.IsVariadic(), Code: code}, nil
+}
+
+func isConvertible(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return true
+	case reflect.Slice:
+		return isConvertible(t.Elem())
+	}
+	return t == valueType
+}
+
+// fromValue converts a flylang value to a Go value of type t.
+func fromValue(value Value, t reflect.Type) (reflect.Value, error) {
+	if t == valueType {
+		if value == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(&value).Elem(), nil
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := value.(ast.LiteralInteger); ok && !v.OverflowInt(i.Value) {
+			v.SetInt(i.Value)
+			return v, nil
+		}
+		return v, fmt.Errorf("expected integer, got %v", value)
+	case reflect.Float32, reflect.Float64:
+		if f, ok := ast.ToFloat(value); ok {
+			v.SetFloat(f)
+			return v, nil
+		}
+		return v, fmt.Errorf("expected real, got %v", value)
+	case reflect.Bool:
+		if b, ok := value.(ast.LiteralBoolean); ok {
+			v.SetBool(b.Value)
+			return v, nil
+		}
+		return v, fmt.Errorf("expected boolean, got %v", value)
+	case reflect.String:
+		if s, ok := value.(ast.LiteralString); ok {
+			v.SetString(s.Value)
+			return v, nil
+		}
+		return v, fmt.Errorf("expected string, got %v", value)
+	case reflect.Slice:
+		lst, ok := value.(ast.List)
+		if !ok {
+			return v, fmt.Errorf("expected list, got %v", value)
+		}
+		elements := lst.GetElements()
+		v = reflect.MakeSlice(t, len(elements), len(elements))
+		for i, elem := range elements {
+			ev, err := fromValue(elem, t.Elem())
+			if err != nil {
+				return v, err
+			}
+			v.Index(i).Set(ev)
+		}
+		return v, nil
+	}
+	return v, fmt.Errorf("unsupported type %s", t)
+}
+
+// toValue converts a Go value to a flylang value.
+func toValue(v reflect.Value) (Value, error) {
+	if v.Type() == valueType {
+		if v.IsNil() {
+			return ast.LiteralNull{}, nil
+		}
+		return v.Interface().(Value), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ast.LiteralInteger{Value: v.Int()}, nil
+	case reflect.Float32, reflect.Float64:
+		return ast.LiteralReal{Value: v.Float()}, nil
+	case reflect.Bool:
+		return ast.LiteralBoolean{Value: v.Bool()}, nil
+	case reflect.String:
+		return ast.LiteralString{Value: v.String()}, nil
+	case reflect.Slice:
+		elements := make([]ast.Element, v.Len())
+		for i := range elements {
+			elem, err := toValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = elem
+		}
+		return ast.ListElement{Elements: elements}, nil
+	}
+	return nil, ast.NewRuntimeError(ast.ErrorTypeMismatch, "unsupported Go value %v", v)
+}
@@ -0,0 +1,104 @@
+package flylang
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/flychario/flylang/ast"
+)
+
+func TestRegisterFunc(t *testing.T) {
+	errNegative := errors.New("negative input")
+
+	in := New()
+	for name, fn := range map[string]interface{}{
+		"double": func(n int64) int64 { return 2 * n },
+		"half":   func(x float64) float64 { return x / 2 },
+		"sum": func(xs ...int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"words": func(s string) []string { return strings.Fields(s) },
+		"count": func(xs []bool, v bool) (n int) {
+			for _, x := range xs {
+				if x == v {
+					n++
+				}
+			}
+			return n
+		},
+		"check": func(n int) (bool, error) {
+			if n < 0 {
+				return false, errNegative
+			}
+			return true, nil
+		},
+		"first": func(xs []Value) Value { return xs[0] },
+		"noop":  func() {},
+	} {
+		if err := in.RegisterFunc(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		program string
+		want    string
+	}{
+		{"(double 21)", "42"},
+		{"(half 3)", "1.5"},
+		{"(half 1/2)", "0.25"},
+		{"(half 36893488147419103232)", "18446744073709552000.0"},
+		{"(sum)", "0"},
+		{"(sum 1 2 3)", "6"},
+		{`(words " a b  c ")`, `("a" "b" "c")`},
+		{"(count '(true false true) true)", "2"},
+		{"(check 1)", "true"},
+		{"(first '(a b))", "a"},
+		{"(noop)", "null"},
+	} {
+		res, err := in.EvalString(test.program)
+		if err != nil {
+			t.Errorf("%s: %v", test.program, err)
+		} else if ast.Print(res) != test.want {
+			t.Errorf("%s: expected %s, got %s", test.program, test.want, ast.Print(res))
+		}
+	}
+
+	for _, test := range []struct {
+		program string
+		kind    ast.ErrorKind
+	}{
+		{"(double 1.5)", ast.ErrorTypeMismatch},
+		{"(double 1 2)", ast.ErrorArityMismatch},
+		{"(count '(1) true)", ast.ErrorTypeMismatch},
+		{"(sum 1 true)", ast.ErrorTypeMismatch},
+		{"(check -1)", ast.ErrorRuntime},
+	} {
+		_, err := in.EvalString(test.program)
+		var rerr *ast.RuntimeError
+		if !errors.As(err, &rerr) || rerr.Kind != test.kind {
+			t.Errorf("%s: expected %s, got %v", test.program, test.kind, err)
+		}
+	}
+
+	if _, err := in.EvalString("(check -5)"); !errors.Is(err, errNegative) {
+		t.Errorf("expected the error returned by check, got %v", err)
+	}
+
+	for _, fn := range []interface{}{
+		nil,
+		42,
+		func(m map[string]int) {},
+		func() (int, int) { return 0, 0 },
+		func() chan int { return nil },
+	} {
+		if err := in.RegisterFunc("bad", fn); err == nil {
+			t.Errorf("expected an error registering %T", fn)
+		}
+	}
+}
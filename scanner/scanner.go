@@ -1,15 +1,27 @@
 package scanner
 
 import (
+	"bytes"
 	"github.com/flychario/flylang/token"
+	"strings"
 	"unicode/utf8"
 )
 
+// A Mode controls the scanner behavior.
+type Mode uint
+
+const (
+	ScanComments Mode = 1 << iota // return comments as COMMENT tokens
+)
+
 type Scanner struct {
-	src    []byte // source
-	ch     rune   // current character
-	offset int    // current offset
-	prev   rune   // previous character
+	Mode Mode // scanning mode, kept by Init
+
+	src      []byte // source
+	ch       rune   // current character
+	chOffset int    // offset of current character
+	offset   int    // reading offset
+	prev     rune   // previous character
 
 	lineOffset int // offset of current line
 	line       int // current line
@@ -25,7 +37,11 @@ func (s *Scanner) Init(src []byte) {
 }
 
 func (s *Scanner) next() {
+	s.chOffset = s.offset
 	if s.offset >= len(s.src) {
+		if s.ch != -1 {
+			s.advanceColumn()
+		}
 		s.prev = s.ch
 		s.ch = -1 // eof
 	} else {
@@ -40,17 +56,23 @@ func (s *Scanner) next() {
 			}
 		}
 		s.offset += w
-		s.lineOffset += 1
-		if r == '\n' {
-			s.line++
-			s.lineOffset = 0
-		}
+		s.advanceColumn()
 
 		s.prev = s.ch
 		s.ch = r
 	}
 }
 
+// advanceColumn moves the line position past the current character. A
+// newline belongs to the line it ends.
+func (s *Scanner) advanceColumn() {
+	if s.ch == '\n' {
+		s.line++
+		s.lineOffset = 0
+	}
+	s.lineOffset += 1
+}
+
 func isLetter(ch rune) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z'
 }
@@ -59,22 +81,46 @@ func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// isIdentifierSymbol reports whether ch may appear in an identifier after
+// its first letter, as in string->number or index-of.
+func isIdentifierSymbol(ch rune) bool {
+	return strings.ContainsRune("-_<>*?!=", ch)
+}
+
 func (s *Scanner) scanIdentifier() string {
-	var buf [64]byte
-	n := 0
-	for isLetter(s.ch) || isDigit(s.ch) {
-		if n < len(buf) {
-			buf[n] = byte(s.ch)
+	start := s.chOffset
+	s.next()
+	for isLetter(s.ch) || isDigit(s.ch) || isIdentifierSymbol(s.ch) {
+		s.next()
+	}
+	return string(s.src[start:s.chOffset])
+}
+
+// scanString scans a double-quoted string literal. The literal is returned
+// as written, including quotes and escape sequences.
+func (s *Scanner) scanString() (token.Token, string) {
+	start := s.chOffset
+	s.next()
+	for s.ch != '"' {
+		if s.ch == -1 {
+			return token.ILLEGAL, string(s.src[start:s.chOffset])
+		}
+		if s.ch == '\\' {
+			s.next()
+			if s.ch == -1 {
+				continue
+			}
 		}
-		n++
 		s.next()
 	}
-	return string(buf[0:n])
+	s.next()
+	return token.STRING, string(s.src[start:s.chOffset])
 }
 
+// scanNumber scans the digits of a number, which may be of any length. A
+// slash between two integers makes a rational, like 1/3.
 func (s *Scanner) scanNumber() (token.Token, string) {
-	var buf [64]byte
-	i := 0
+	start := s.chOffset
 	tok := token.INTEGER
 	for isDigit(s.ch) || s.ch == '.' {
 		if s.ch == '.' && tok == token.INTEGER {
@@ -82,35 +128,104 @@ func (s *Scanner) scanNumber() (token.Token, string) {
 		} else if s.ch == '.' && tok == token.REAL {
 			tok = token.ILLEGAL
 		}
-		if i < len(buf) {
-			buf[i] = byte(s.ch)
-		}
-		i++
 		s.next()
 	}
+	if s.ch == '/' && tok == token.INTEGER {
+		tok = token.RATIONAL
+		if s.next(); !isDigit(s.ch) {
+			tok = token.ILLEGAL
+		}
+		for isDigit(s.ch) {
+			s.next()
+		}
+	}
 
-	if isLetter(s.ch) || s.prev == '.' {
+	if isLetter(s.ch) || s.prev == '.' || (tok == token.RATIONAL && (s.ch == '.' || s.ch == '/')) {
 		tok = token.ILLEGAL
 	}
 
-	return tok, string(buf[0:i])
+	return tok, string(s.src[start:s.chOffset])
+}
+
+// scanNonFinite scans inf.0 or nan.0 after the sign of the reals +inf.0,
+// -inf.0 and +nan.0, and reports whether one was there.
+func (s *Scanner) scanNonFinite() (string, bool) {
+	for _, word := range []string{"inf.0", "nan.0"} {
+		end := s.chOffset + len(word)
+		if !bytes.HasPrefix(s.src[s.chOffset:], []byte(word)) {
+			continue
+		}
+		if end < len(s.src) && (isLetter(rune(s.src[end])) || isDigit(rune(s.src[end]))) {
+			continue
+		}
+		for s.chOffset < end {
+			s.next()
+		}
+		return word, true
+	}
+	return "", false
+}
+
+// Pos returns the position of the current character, which is immediately
+// after the last scanned token.
+func (s *Scanner) Pos() token.Position {
+	return token.Position{
+		Offset: s.chOffset,
+		Line:   s.line,
+		Column: s.lineOffset,
+	}
+}
+
+// scanLineComment scans a comment from ';' to the end of the line.
+func (s *Scanner) scanLineComment() string {
+	start := s.chOffset
+	for s.ch != '\n' && s.ch != -1 {
+		s.next()
+	}
+	return string(s.src[start:s.chOffset])
+}
+
+// scanBlockComment scans a possibly nested #| ... |# comment. The opening
+// '#' is at start and the current character is the '|' following it.
+func (s *Scanner) scanBlockComment(start int) (string, bool) {
+	s.next()
+	for depth := 1; depth > 0; {
+		switch s.ch {
+		case -1:
+			return string(s.src[start:s.chOffset]), false
+		case '#':
+			s.next()
+			if s.ch == '|' {
+				depth++
+				s.next()
+			}
+		case '|':
+			s.next()
+			if s.ch == '#' {
+				depth--
+				s.next()
+			}
+		default:
+			s.next()
+		}
+	}
+	return string(s.src[start:s.chOffset]), true
 }
 
 func (s *Scanner) Scan() (pos token.Position, tok token.Token, lit string) {
+scanAgain:
+	lit = ""
+
 	// skip white space
 	for s.ch == ' ' || s.ch == '\t' || s.ch == '\n' || s.ch == '\r' {
 		s.next()
 	}
 
-	// current position
-	pos = token.Position{
-		Offset: s.offset,
-		Line:   s.line,
-		Column: s.lineOffset,
-	}
+	pos = s.Pos()
 
-	// identifier or keyword
-	if isLetter(s.ch) {
+	// identifier or keyword, & starts lambda list keywords like &rest and _
+	// the placeholder in syntax-rules patterns
+	if isLetter(s.ch) || s.ch == '&' || s.ch == '_' {
 		lit = s.scanIdentifier()
 		tok = token.Lookup(lit)
 
@@ -131,17 +246,81 @@ func (s *Scanner) Scan() (pos token.Position, tok token.Token, lit string) {
 		tok = token.LPAREN
 	case ')':
 		tok = token.RPAREN
+	case '{':
+		tok = token.LBRACE
+	case '}':
+		tok = token.RBRACE
 	case '\'':
 		tok = token.SHORT_QUOTE
+	case '`':
+		tok = token.BACKQUOTE
+	case ',':
+		tok = token.UNQUOTE
+		if s.next(); s.ch == '@' {
+			s.next()
+			tok = token.UNQUOTE_SPLICING
+		}
+		return
+	case '"':
+		tok, lit = s.scanString()
+		return
+	case '.':
+		// ... is the ellipsis of syntax-rules, other dots start no token
+		if bytes.HasPrefix(s.src[s.chOffset:], []byte("...")) {
+			s.next()
+			s.next()
+			tok, lit = token.IDENTIFIER, "..."
+		} else {
+			tok = token.ILLEGAL
+		}
 	case '+':
 		s.next()
+		if word, ok := s.scanNonFinite(); ok {
+			tok, lit = token.REAL, "+"+word
+			return
+		}
 		tok, lit = s.scanNumber()
 		return
 	case '-':
 		s.next()
+		if word, ok := s.scanNonFinite(); ok {
+			tok, lit = token.REAL, "-"+word
+			return
+		}
 		tok, lit = s.scanNumber()
 		lit = "-" + lit
 		return
+	case ';':
+		lit = s.scanLineComment()
+		if s.Mode&ScanComments == 0 {
+			goto scanAgain
+		}
+		tok = token.COMMENT
+		return
+	case '#':
+		start := s.chOffset
+		s.next()
+		switch s.ch {
+		case '|':
+			var ok bool
+			lit, ok = s.scanBlockComment(start)
+			if !ok {
+				tok = token.ILLEGAL
+			} else if s.Mode&ScanComments == 0 {
+				goto scanAgain
+			} else {
+				tok = token.COMMENT
+			}
+		case ';':
+			s.next()
+			tok, lit = token.DATUM_COMMENT, "#;"
+		case '(':
+			s.next()
+			tok = token.VECTOR
+		default:
+			tok = token.ILLEGAL
+		}
+		return
 	default:
 		tok = token.ILLEGAL
 	}
@@ -21,6 +21,18 @@ func TestNumbers(t *testing.T) {
 		{"1.", token.ILLEGAL, "1."},
 		{".1", token.ILLEGAL, ""},
 		{"1abc", token.ILLEGAL, "1"},
+		{"1/3", token.RATIONAL, "1/3"},
+		{"-10/4", token.RATIONAL, "-10/4"},
+		{"1/", token.ILLEGAL, "1/"},
+		{"1/2/3", token.ILLEGAL, "1/2"},
+		{"1/2.5", token.ILLEGAL, "1/2"},
+		{"+inf.0", token.REAL, "+inf.0"},
+		{"-inf.0", token.REAL, "-inf.0"},
+		{"+nan.0", token.REAL, "+nan.0"},
+		{"+inf.0)", token.REAL, "+inf.0"},
+		{"+inf.00", token.ILLEGAL, ""},
+		{"inf.0", token.IDENTIFIER, "inf"},
+		{"123456789012345678901234567890123456789012345678901234567890123456789", token.INTEGER, "123456789012345678901234567890123456789012345678901234567890123456789"},
 	} {
 		var s Scanner
 		s.Init([]byte(test.input))
@@ -44,12 +56,27 @@ func TestIdentifiers(t *testing.T) {
 		{"abc", token.IDENTIFIER, "abc"},
 		{"a1", token.IDENTIFIER, "a1"},
 		{"a1b2c3", token.IDENTIFIER, "a1b2c3"},
+		{"&rest", token.IDENTIFIER, "&rest"},
+		{"&optional", token.IDENTIFIER, "&optional"},
 
 		{"setq", token.SETQ, "setq"},
 		{"func", token.FUNC, "func"},
 		{"lambda", token.LAMBDA, "lambda"},
 		{"prog", token.PROG, "prog"},
 		{"cond", token.COND, "cond"},
+		{"if", token.IF, "if"},
+		{"let", token.LET, "let"},
+		{"let*", token.LET_STAR, "let*"},
+		{"letrec", token.LETREC, "letrec"},
+		{"and", token.AND, "and"},
+		{"or", token.OR, "or"},
+		{"when", token.WHEN, "when"},
+		{"unless", token.UNLESS, "unless"},
+		{"defmacro", token.DEFMACRO, "defmacro"},
+		{"define-syntax", token.DEFINE_SYNTAX, "define-syntax"},
+		{"syntax-rules", token.SYNTAX_RULES, "syntax-rules"},
+		{"...", token.IDENTIFIER, "..."},
+		{"_", token.IDENTIFIER, "_"},
 		{"while", token.WHILE, "while"},
 		{"return", token.RETURN, "return"},
 		{"break", token.BREAK, "break"},
@@ -136,3 +163,121 @@ func TestProgram(t *testing.T) {
 	}
 
 }
+
+func TestComments(t *testing.T) {
+	src := []byte(`; line comment
+(plus 1 ; trailing
+  #| block #| nested |# still comment |# 2)
+#;(skipped) x
+#| unterminated`)
+	want := []struct {
+		tok token.Token
+		lit string
+	}{
+		{token.COMMENT, "; line comment"},
+		{token.LPAREN, ""},
+		{token.IDENTIFIER, "plus"},
+		{token.INTEGER, "1"},
+		{token.COMMENT, "; trailing"},
+		{token.COMMENT, "#| block #| nested |# still comment |#"},
+		{token.INTEGER, "2"},
+		{token.RPAREN, ""},
+		{token.DATUM_COMMENT, "#;"},
+		{token.LPAREN, ""},
+		{token.IDENTIFIER, "skipped"},
+		{token.RPAREN, ""},
+		{token.IDENTIFIER, "x"},
+		{token.ILLEGAL, "#| unterminated"},
+		{token.EOF, ""},
+	}
+
+	for _, mode := range []Mode{0, ScanComments} {
+		var s Scanner
+		s.Mode = mode
+		s.Init(src)
+		for _, w := range want {
+			if w.tok == token.COMMENT && mode&ScanComments == 0 {
+				continue
+			}
+			_, tok, lit := s.Scan()
+			if tok != w.tok {
+				t.Errorf("mode %d: expected token %s, got %s", mode, w.tok, tok)
+			}
+			if lit != w.lit {
+				t.Errorf("mode %d: expected literal %s, got %s", mode, w.lit, lit)
+			}
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		tok   token.Token
+		lit   string
+	}{
+		{`""`, token.STRING, `""`},
+		{`"abc"`, token.STRING, `"abc"`},
+		{`"a \"b\" c"`, token.STRING, `"a \"b\" c"`},
+		{`"a\\" b`, token.STRING, `"a\\"`},
+		{"\"multi\nline\"", token.STRING, "\"multi\nline\""},
+		{`"unterminated`, token.ILLEGAL, `"unterminated`},
+		{`"escaped end\"`, token.ILLEGAL, `"escaped end\"`},
+		{"string->number", token.IDENTIFIER, "string->number"},
+		{"index-of", token.IDENTIFIER, "index-of"},
+	} {
+		var s Scanner
+		s.Init([]byte(test.input))
+		_, tok, lit := s.Scan()
+		if tok != test.tok {
+			t.Errorf("expected token %s, got %s. input: %s", test.tok, tok, test.input)
+		}
+		if lit != test.lit {
+			t.Errorf("expected literal %s, got %s", test.lit, lit)
+		}
+	}
+}
+
+func TestQuasiquote(t *testing.T) {
+	src := []byte("`(a ,b ,@c)")
+	want := []token.Token{
+		token.BACKQUOTE,
+		token.LPAREN,
+		token.IDENTIFIER,
+		token.UNQUOTE,
+		token.IDENTIFIER,
+		token.UNQUOTE_SPLICING,
+		token.IDENTIFIER,
+		token.RPAREN,
+		token.EOF,
+	}
+	var s Scanner
+	s.Init(src)
+	for _, w := range want {
+		if _, tok, _ := s.Scan(); tok != w {
+			t.Errorf("expected token %s, got %s", w, tok)
+		}
+	}
+}
+
+func TestBrackets(t *testing.T) {
+	src := []byte("{a #(1)} #x")
+	want := []token.Token{
+		token.LBRACE,
+		token.IDENTIFIER,
+		token.VECTOR,
+		token.INTEGER,
+		token.RPAREN,
+		token.RBRACE,
+		token.ILLEGAL,
+		token.IDENTIFIER,
+		token.EOF,
+	}
+	var s Scanner
+	s.Init(src)
+	for _, w := range want {
+		if _, tok, _ := s.Scan(); tok != w {
+			t.Errorf("expected token %s, got %s", w, tok)
+		}
+	}
+}
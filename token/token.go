@@ -1,20 +1,33 @@
 package token
 
+import "fmt"
+
 type Token int
 
 const (
 	ILLEGAL Token = iota
 	EOF
+	COMMENT
+	DATUM_COMMENT
 
 	IDENTIFIER
 	INTEGER
 	REAL
+	RATIONAL
 	BOOLEAN
 	NULL
+	STRING
+	VALUE // an element handed to the parser as it is, see parser.Reparse
 
 	LPAREN
 	RPAREN
+	LBRACE
+	RBRACE
+	VECTOR
 	SHORT_QUOTE
+	BACKQUOTE
+	UNQUOTE
+	UNQUOTE_SPLICING
 	QUOTE
 	PLUS
 	MINUS
@@ -24,6 +37,17 @@ const (
 	LAMBDA
 	PROG
 	COND
+	IF
+	LET
+	LET_STAR
+	LETREC
+	AND
+	OR
+	WHEN
+	UNLESS
+	DEFMACRO
+	DEFINE_SYNTAX
+	SYNTAX_RULES
 	WHILE
 	RETURN
 	BREAK
@@ -33,45 +57,86 @@ var tokens = [...]string{
 	ILLEGAL: "ILLEGAL",
 	EOF:     "EOF",
 
+	COMMENT:       "COMMENT",
+	DATUM_COMMENT: "#;",
+
 	IDENTIFIER: "IDENTIFIER",
 	INTEGER:    "INTEGER",
 	REAL:       "REAL",
+	RATIONAL:   "RATIONAL",
 	BOOLEAN:    "BOOLEAN",
+	NULL:       "NULL",
+	STRING:     "STRING",
+	VALUE:      "VALUE",
+
+	LPAREN:           "(",
+	RPAREN:           ")",
+	LBRACE:           "{",
+	RBRACE:           "}",
+	VECTOR:           "#(",
+	SHORT_QUOTE:      "'",
+	BACKQUOTE:        "`",
+	UNQUOTE:          ",",
+	UNQUOTE_SPLICING: ",@",
+	PLUS:             "+",
+	MINUS:            "-",
 
-	LPAREN:      "(",
-	RPAREN:      ")",
-	SHORT_QUOTE: "'",
-	PLUS:        "+",
-	MINUS:       "-",
-
-	QUOTE:  "quote",
-	SETQ:   "setq",
-	FUNC:   "func",
-	LAMBDA: "lambda",
-	PROG:   "prog",
-	COND:   "cond",
-	WHILE:  "while",
-	RETURN: "return",
-	BREAK:  "break",
+	QUOTE:         "quote",
+	SETQ:          "setq",
+	FUNC:          "func",
+	LAMBDA:        "lambda",
+	PROG:          "prog",
+	COND:          "cond",
+	IF:            "if",
+	LET:           "let",
+	LET_STAR:      "let*",
+	LETREC:        "letrec",
+	AND:           "and",
+	OR:            "or",
+	WHEN:          "when",
+	UNLESS:        "unless",
+	DEFMACRO:      "defmacro",
+	DEFINE_SYNTAX: "define-syntax",
+	SYNTAX_RULES:  "syntax-rules",
+	WHILE:         "while",
+	RETURN:        "return",
+	BREAK:         "break",
 }
 
 var keywords = map[string]Token{
-	"setq":        SETQ,
-	"func":        FUNC,
-	"lambda":      LAMBDA,
-	"prog":        PROG,
-	"cond":        COND,
-	"while":       WHILE,
-	"return":      RETURN,
-	"break":       BREAK,
-	"short_quote": SHORT_QUOTE,
-	"quote":       QUOTE,
+	"setq":          SETQ,
+	"func":          FUNC,
+	"lambda":        LAMBDA,
+	"prog":          PROG,
+	"cond":          COND,
+	"if":            IF,
+	"let":           LET,
+	"let*":          LET_STAR,
+	"letrec":        LETREC,
+	"and":           AND,
+	"or":            OR,
+	"when":          WHEN,
+	"unless":        UNLESS,
+	"defmacro":      DEFMACRO,
+	"define-syntax": DEFINE_SYNTAX,
+	"syntax-rules":  SYNTAX_RULES,
+	"while":         WHILE,
+	"return":        RETURN,
+	"break":         BREAK,
+	"short_quote":   SHORT_QUOTE,
+	"quote":         QUOTE,
 }
 
 func (tok Token) String() string {
 	return tokens[tok]
 }
 
+// IsKeyword reports whether ident is a keyword.
+func IsKeyword(ident string) bool {
+	_, ok := keywords[ident]
+	return ok
+}
+
 func isBoolean(ident string) bool {
 	return ident == "true" || ident == "false"
 }
@@ -94,8 +159,21 @@ func Lookup(ident string) Token {
 }
 
 type Position struct {
-	//Filename string // filename, if any
-	Offset int // offset, starting at 0
-	Line   int // line number, starting at 1
-	Column int // column number, starting at 1 (byte count)
+	Filename string // filename, if any
+	Offset   int    // offset, starting at 0
+	Line     int    // line number, starting at 1
+	Column   int    // column number, starting at 1 (byte count)
+}
+
+// IsValid reports whether the position is set.
+func (pos Position) IsValid() bool { return pos.Line > 0 }
+
+// String returns the position in the form file:line:column, or
+// line:column when there is no file name.
+func (pos Position) String() string {
+	s := pos.Filename
+	if s != "" {
+		s += ":"
+	}
+	return fmt.Sprintf("%s%d:%d", s, pos.Line, pos.Column)
 }
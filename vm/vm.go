@@ -0,0 +1,513 @@
+// Package vm runs programs compiled by package compiler. Programs behave as
+// when they are evaluated by package ast, whose values, builtins and global
+// context the machine shares.
+package vm
+
+import (
+	"github.com/flychario/flylang/ast"
+	"github.com/flychario/flylang/compiler"
+	"github.com/flychario/flylang/token"
+)
+
+// VM is a stack machine. Definitions made by one run are visible to the
+// following ones through the global context.
+type VM struct {
+	globals *ast.Context
+	stack   []ast.Element
+	frames  []frame
+}
+
+type frame struct {
+	fn    *compiler.Function
+	ip    int
+	env   *Env
+	bp    int // start of the frame on the stack, where the called closure is
+	nargs int
+	site  *compiler.Site // call of the frame, nil if it was called from outside of the machine
+}
+
+// Env is the environment of a call of a closure or of a let.
+type Env struct {
+	slots   []ast.Element
+	parent  *Env
+	scope   *compiler.Scope
+	context *ast.Context // sharing slots with code evaluated by package ast, once made
+}
+
+func (e *Env) up(depth int) *Env {
+	for ; depth > 0; depth-- {
+		e = e.parent
+	}
+	return e
+}
+
+// Closure is a compiled lambda with the environment it was created in.
+type Closure struct {
+	Fn  *compiler.Function
+	env *Env
+	vm  *VM
+}
+
+func (cl *Closure) ElementType() ast.ElementType { return ast.ElementTypeLambda }
+func (cl *Closure) Pos() token.Position          { return cl.Fn.Pos() }
+func (cl *Closure) End() token.Position          { return cl.Fn.End() }
+func (cl *Closure) String() string               { return cl.Fn.String() }
+
+func (cl *Closure) Eval(c *ast.Context) (ast.Element, error) {
+	return cl, nil
+}
+
+// Call runs the closure on its machine, so it can be called by builtins and
+// by code evaluated by package ast.
+func (cl *Closure) Call(c *ast.Context, args []ast.Element) (ast.Element, error) {
+	m := cl.vm
+	base := len(m.frames)
+	bp := len(m.stack)
+	m.stack = append(append(m.stack, cl), args...)
+	m.frames = append(m.frames, frame{fn: cl.Fn, env: cl.newEnv(), bp: bp, nargs: len(args)})
+	return m.run(base)
+}
+
+func (cl *Closure) newEnv() *Env {
+	return &Env{slots: make([]ast.Element, len(cl.Fn.Scope.Names)), parent: cl.env, scope: cl.Fn.Scope}
+}
+
+// New returns a machine whose globals are in the context globals.
+func New(globals *ast.Context) *VM {
+	return &VM{globals: globals}
+}
+
+// Eval compiles and runs the elements of p one after the other, like
+// ast.Program.EvalEach, passing each through prepare, if given, before it is
+// compiled.
+func (m *VM) Eval(p ast.Program, prepare func(ast.Element) (ast.Element, error)) (res ast.Element, err error) {
+	var mode compiler.Mode
+	if m.globals.Runtime.StrictBooleans {
+		mode |= compiler.StrictBooleans
+	}
+	for _, e := range p.Elements {
+		if prepare != nil {
+			if e, err = prepare(e); err != nil {
+				return nil, err
+			}
+		}
+		fn, err := compiler.Compile(e, mode)
+		if err != nil {
+			return nil, err
+		}
+		if res, err = m.Run(fn); err != nil {
+			if sig, ok := err.(*ast.ReturnSignal); ok {
+				return sig.Value, nil
+			}
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Run runs a compiled top-level element. A return in it ends the run with
+// an *ast.ReturnSignal holding the returned value.
+func (m *VM) Run(fn *compiler.Function) (ast.Element, error) {
+	base := len(m.frames)
+	m.frames = append(m.frames, frame{fn: fn, bp: len(m.stack)})
+	return m.run(base)
+}
+
+// run executes instructions until the frame at base returns.
+func (m *VM) run(base int) (res ast.Element, err error) {
+	sp := m.frames[base].bp
+	defer func() {
+		if r := recover(); r != nil {
+			m.frames, m.stack = m.frames[:base], m.stack[:sp]
+			panic(r)
+		}
+	}()
+
+	for {
+		f := &m.frames[len(m.frames)-1]
+		code := f.fn.Code
+		ip := f.ip
+		op := compiler.Opcode(code[ip])
+		f.ip += op.Width()
+
+		switch op {
+		case compiler.OpConst:
+			m.push(f.fn.Consts[compiler.Operand(code, ip, 0)])
+		case compiler.OpPop:
+			m.stack = m.stack[:len(m.stack)-1]
+		case compiler.OpLocal:
+			env := f.env.up(compiler.Operand(code, ip, 0))
+			m.push(env.slots[compiler.Operand(code, ip, 1)])
+		case compiler.OpSetLocal:
+			env := f.env.up(compiler.Operand(code, ip, 0))
+			env.slots[compiler.Operand(code, ip, 1)] = m.top()
+		case compiler.OpGlobal:
+			name := f.fn.Names[compiler.Operand(code, ip, 0)]
+			if v := m.globals.Get(name); v != nil {
+				m.push(v)
+			} else {
+				err = undefined(name, f.fn.PosAt(ip))
+			}
+		case compiler.OpSetGlobal:
+			m.globals.Set(f.fn.Names[compiler.Operand(code, ip, 0)], m.top())
+		case compiler.OpDefGlobal:
+			m.globals.Values[f.fn.Names[compiler.Operand(code, ip, 0)]] = m.top()
+		case compiler.OpLookup:
+			r := &f.fn.Refs[compiler.Operand(code, ip, 0)]
+			if v := m.lookup(f.env, r); v != nil {
+				m.push(v)
+			} else {
+				err = undefined(r.Name, f.fn.PosAt(ip))
+			}
+		case compiler.OpSetq:
+			m.setq(f.env, &f.fn.Refs[compiler.Operand(code, ip, 0)], m.top())
+		case compiler.OpClosure:
+			m.push(&Closure{Fn: f.fn.Functions[compiler.Operand(code, ip, 0)], env: f.env, vm: m})
+		case compiler.OpCall, compiler.OpTailCall:
+			site := &f.fn.Sites[compiler.Operand(code, ip, 1)]
+			err = m.call(f, op, compiler.Operand(code, ip, 0), site)
+			if sig, ok := err.(*ast.ReturnSignal); ok {
+				// (return x) evaluated by a builtin returns from the caller
+				f := &m.frames[len(m.frames)-1]
+				if f.fn.Scope == nil {
+					m.ret(base, sig.Value)
+					return nil, sig
+				}
+				if m.ret(base, sig.Value) {
+					return sig.Value, nil
+				}
+				err = nil
+			}
+		case compiler.OpReturn:
+			v := m.pop()
+			if m.ret(base, v) {
+				return v, nil
+			}
+		case compiler.OpHalt:
+			v := m.pop()
+			m.ret(base, v)
+			return nil, &ast.ReturnSignal{Value: v}
+		case compiler.OpJump:
+			f.ip = compiler.Operand(code, ip, 0)
+		case compiler.OpJumpIfFalse:
+			var ok bool
+			if ok, err = m.condition(m.pop(), compiler.Operand(code, ip, 1), f.fn.PosAt(ip)); err == nil && !ok {
+				f.ip = compiler.Operand(code, ip, 0)
+			}
+		case compiler.OpAnd, compiler.OpOr:
+			var ok bool
+			if ok, err = m.truth(m.top(), f.fn.PosAt(ip)); err == nil {
+				if ok == (op == compiler.OpOr) {
+					f.ip = compiler.Operand(code, ip, 0)
+				} else {
+					m.pop()
+				}
+			}
+		case compiler.OpTruth:
+			if _, ok := m.top().(ast.LiteralBoolean); !ok {
+				err = logicalError(m.top(), f.fn.PosAt(ip))
+			}
+		case compiler.OpWhen, compiler.OpUnless:
+			var ok bool
+			if ok, err = m.truth(m.pop(), f.fn.PosAt(ip)); err == nil && ok == (op == compiler.OpUnless) {
+				f.ip = compiler.Operand(code, ip, 0)
+			}
+		case compiler.OpPushEnv:
+			scope := f.fn.Scopes[compiler.Operand(code, ip, 0)]
+			f.env = &Env{slots: make([]ast.Element, len(scope.Names)), parent: f.env, scope: scope}
+		case compiler.OpPopEnv:
+			f.env = f.env.parent
+		case compiler.OpQuasiquote:
+			n := compiler.Operand(code, ip, 0)
+			q := f.fn.Consts[compiler.Operand(code, ip, 1)].(ast.Quasiquote)
+			values := m.stack[len(m.stack)-n:]
+			var v ast.Element
+			v, err = q.Fill(func(ast.Element) (ast.Element, error) {
+				v := values[0]
+				values = values[1:]
+				return v, nil
+			})
+			m.stack = m.stack[:len(m.stack)-n]
+			m.push(v)
+		case compiler.OpMap:
+			n := compiler.Operand(code, ip, 0)
+			var v ast.Map
+			if v, err = ast.NewMap(ast.Span{}, m.stack[len(m.stack)-n:]); err != nil {
+				err.(*ast.RuntimeError).Pos = f.fn.PosAt(ip)
+			}
+			m.stack = m.stack[:len(m.stack)-n]
+			m.push(v)
+		case compiler.OpVector:
+			n := compiler.Operand(code, ip, 0)
+			elements := make([]ast.Element, n)
+			copy(elements, m.stack[len(m.stack)-n:])
+			m.stack = m.stack[:len(m.stack)-n]
+			m.push(ast.Vector{Elements: elements})
+		case compiler.OpEval:
+			var v ast.Element
+			v, err = f.fn.Consts[compiler.Operand(code, ip, 0)].Eval(m.context(f.env))
+			m.push(v)
+		case compiler.OpFail:
+			kind := ast.ErrorKind(compiler.Operand(code, ip, 0))
+			message := f.fn.Consts[compiler.Operand(code, ip, 1)].(ast.LiteralString).Value
+			rerr := ast.NewRuntimeError(kind, "%s", message)
+			rerr.Pos = f.fn.PosAt(ip)
+			err = rerr
+		case compiler.OpBreakError:
+			err = ast.NewRuntimeError(ast.ErrorRuntime, "break outside of while")
+		case compiler.OpArg:
+			if i := compiler.Operand(code, ip, 0); i < f.nargs {
+				f.env.slots[compiler.Operand(code, ip, 1)] = m.stack[f.bp+1+i]
+			} else {
+				err = ast.NewRuntimeError(ast.ErrorArityMismatch, "not enough arguments in lambda call")
+			}
+		case compiler.OpOptArg:
+			if i := compiler.Operand(code, ip, 0); i < f.nargs {
+				f.env.slots[compiler.Operand(code, ip, 1)] = m.stack[f.bp+1+i]
+				f.ip = compiler.Operand(code, ip, 2)
+			}
+		case compiler.OpRestArg:
+			args := m.stack[f.bp+1 : f.bp+1+f.nargs]
+			if i := compiler.Operand(code, ip, 0); i < len(args) {
+				args = args[i:]
+			} else {
+				args = nil
+			}
+			f.env.slots[compiler.Operand(code, ip, 1)] = ast.ListElement{Elements: append([]ast.Element{}, args...)}
+		case compiler.OpArgsEnd:
+			if f.nargs > compiler.Operand(code, ip, 0) {
+				err = ast.NewRuntimeError(ast.ErrorArityMismatch, "too many arguments in lambda call")
+			}
+		}
+
+		if err != nil {
+			return nil, m.unwind(base, err)
+		}
+	}
+}
+
+// call calls the function below argc arguments on the stack. A closure gets
+// a new frame, or replaces the frame f for a tail call. Other functions are
+// called right away.
+func (m *VM) call(f *frame, op compiler.Opcode, argc int, site *compiler.Site) error {
+	at := len(m.stack) - argc - 1
+	if b, ok := m.stack[at].(*ast.Builtin); ok && b.Binary != nil && argc == 2 {
+		x, xok := m.stack[at+1].(ast.Literal)
+		y, yok := m.stack[at+2].(ast.Literal)
+		if xok && yok {
+			m.stack = m.stack[:at]
+			res, err := b.Binary(x, y)
+			if err != nil {
+				return traceCall(err, site, b)
+			}
+			m.push(res)
+			return nil
+		}
+	}
+	switch fun := m.stack[at].(type) {
+	case *Closure:
+		env := fun.newEnv()
+		if op == compiler.OpTailCall {
+			copy(m.stack[f.bp:], m.stack[at:])
+			m.stack = m.stack[:f.bp+argc+1]
+			*f = frame{fn: fun.Fn, env: env, bp: f.bp, nargs: argc, site: f.site}
+		} else {
+			m.frames = append(m.frames, frame{fn: fun.Fn, env: env, bp: at, nargs: argc, site: site})
+		}
+		return nil
+	case ast.Callable:
+		args := make([]ast.Element, argc)
+		copy(args, m.stack[at+1:])
+		m.stack = m.stack[:at]
+
+		c := m.globals
+		if needsContext(fun, args) {
+			c = m.context(f.env)
+		}
+		res, err := fun.Call(c, args)
+		switch err.(type) {
+		case nil:
+			m.push(res)
+			return nil
+		case *ast.ReturnSignal:
+			return err
+		case *ast.BreakSignal:
+			return ast.NewRuntimeError(ast.ErrorRuntime, "break outside of while")
+		}
+		return traceCall(err, site, fun)
+	}
+	err := ast.NewRuntimeError(ast.ErrorTypeMismatch, "The first element of a list must be a function")
+	err.Pos = site.Pos
+	return err
+}
+
+// ret returns v from the innermost frame and reports whether that ends the
+// run of the frame at base.
+func (m *VM) ret(base int, v ast.Element) bool {
+	f := m.frames[len(m.frames)-1]
+	m.stack = m.stack[:f.bp]
+	m.frames = m.frames[:len(m.frames)-1]
+	if len(m.frames) == base {
+		return true
+	}
+	m.push(v)
+	return false
+}
+
+// unwind removes the frames of a run after an error and records their
+// calls in it.
+func (m *VM) unwind(base int, err error) error {
+	for len(m.frames) > base {
+		f := m.frames[len(m.frames)-1]
+		m.frames = m.frames[:len(m.frames)-1]
+		m.stack = m.stack[:f.bp]
+		if f.site != nil {
+			err = traceCall(err, f.site, nil)
+		}
+	}
+	return err
+}
+
+// traceCall records a call in the stack of a runtime error, like the
+// evaluator does, and places errors without a position at the call.
+func traceCall(err error, site *compiler.Site, fun ast.Callable) error {
+	rerr, ok := err.(*ast.RuntimeError)
+	if !ok {
+		return err
+	}
+	if !rerr.Pos.IsValid() {
+		rerr.Pos = site.Pos
+	}
+	name := site.Name
+	if name == "" {
+		name = "lambda"
+		if b, ok := fun.(*ast.Builtin); ok {
+			name = b.Name
+		}
+	}
+	rerr.Stack = append(rerr.Stack, ast.Frame{Function: name, Pos: site.Pos})
+	return rerr
+}
+
+func (m *VM) lookup(env *Env, r *compiler.Ref) ast.Element {
+	for _, s := range r.Slots {
+		if v := env.up(s.Depth).slots[s.Index]; v != nil {
+			return v
+		}
+	}
+	return m.globals.Get(r.Name)
+}
+
+// setq assigns v to the nearest variable of a reference, or defines it in
+// the innermost environment.
+func (m *VM) setq(env *Env, r *compiler.Ref, v ast.Element) {
+	for _, s := range r.Slots {
+		if e := env.up(s.Depth); e.slots[s.Index] != nil {
+			e.slots[s.Index] = v
+			return
+		}
+	}
+	for c := m.globals; c != nil; c = c.Parent {
+		if _, ok := c.Values[r.Name]; ok {
+			c.Values[r.Name] = v
+			return
+		}
+	}
+	if len(r.Slots) > 0 && r.Slots[0].Depth == 0 {
+		env.slots[r.Slots[0].Index] = v
+		return
+	}
+	m.globals.Set(r.Name, v)
+}
+
+// needsContext reports whether a call of fun may evaluate code in the
+// context of the call. Builtins evaluate their arguments again, which only
+// changes values other than literals and functions.
+func needsContext(fun ast.Callable, args []ast.Element) bool {
+	if _, ok := fun.(*ast.Builtin); !ok {
+		return true
+	}
+	for _, arg := range args {
+		switch arg.(type) {
+		case ast.Literal, *Closure, *ast.Builtin:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// context returns the context of the variables of env, for code evaluated
+// by package ast. It keeps them in the slots of env, so assignments made by
+// either side, even later by closures made in the context, are seen by both.
+func (m *VM) context(env *Env) *ast.Context {
+	if env == nil {
+		return m.globals
+	}
+	if env.context == nil {
+		env.context = &ast.Context{Parent: m.context(env.parent), Names: env.scope.Names, Slots: env.slots, Runtime: m.globals.Runtime}
+	}
+	return env.context
+}
+
+func (m *VM) truth(v ast.Element, pos token.Position) (bool, error) {
+	switch v := v.(type) {
+	case ast.LiteralBoolean:
+		return v.Value, nil
+	case ast.LiteralNull:
+		if !m.globals.Runtime.StrictBooleans {
+			return false, nil
+		}
+	default:
+		if !m.globals.Runtime.StrictBooleans {
+			return true, nil
+		}
+	}
+	return false, logicalError(v, pos)
+}
+
+func logicalError(v ast.Element, pos token.Position) error {
+	err := ast.NewRuntimeError(ast.ErrorTypeMismatch, "Can't use logical operator on %s", v)
+	err.Pos = pos
+	return err
+}
+
+// condition returns whether v, the test of the form with the given index
+// in compiler.Forms, counts as true.
+func (m *VM) condition(v ast.Element, form int, pos token.Position) (bool, error) {
+	switch v := v.(type) {
+	case ast.LiteralBoolean:
+		return v.Value, nil
+	case ast.LiteralNull:
+		if !m.globals.Runtime.StrictBooleans {
+			return false, nil
+		}
+	default:
+		if !m.globals.Runtime.StrictBooleans {
+			return true, nil
+		}
+	}
+	err := ast.NewRuntimeError(ast.ErrorTypeMismatch, "%s test evaluated to non-boolean %s", compiler.Forms[form], v)
+	err.Pos = pos
+	return false, err
+}
+
+func undefined(name string, pos token.Position) error {
+	err := ast.NewRuntimeError(ast.ErrorUndefinedVariable, "%s", name)
+	err.Pos = pos
+	return err
+}
+
+func (m *VM) push(v ast.Element) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *VM) pop() ast.Element {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+func (m *VM) top() ast.Element {
+	return m.stack[len(m.stack)-1]
+}
@@ -0,0 +1,46 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/flychario/flylang"
+	"github.com/flychario/flylang/ast"
+)
+
+// TestEngines checks that programs give the same results on the virtual
+// machine as on the tree-walking evaluator.
+func TestEngines(t *testing.T) {
+	for _, src := range []string{
+		// closures share the variables they capture
+		"(func counter () (let ((n 0)) (lambda () (setq n (plus n 1))))) (setq c (counter)) (c) (c)",
+		// tail calls do not grow the stack
+		"(func loop (n acc) (cond (equal n 0) acc (loop (minus n 1) (plus acc 1)))) (loop 100000 0)",
+		// setq inside a function assigns an existing global
+		"(setq g 1) (func f () (setq g 2)) (f) g",
+		// prog bodies see the variables of their caller
+		"(func show () (prog () x)) (func f (x) (show)) (f 7)",
+		// builtins call closures and evaluate code in local contexts
+		"(func f (x) (eval (quote (plus x 1)))) (f 41)",
+		"(func twice (g x) (g (g x))) (twice (lambda (y) (times y 3)) 2)",
+		"(setq i 0) (while (less i 10) (setq i (plus i 1)) (cond (equal i 5) (break))) i",
+		"(func f (x) (while true (return (times x 2)))) (f 21)",
+		"(letrec ((even (lambda (n) (if (equal n 0) true (odd (minus n 1))))) (odd (lambda (n) (if (equal n 0) false (even (minus n 1)))))) (even 100))",
+		"(setq x 5) `(1 ,x ,@(cons x (quote (6))))",
+		// closures made by eval share the variables of the function
+		"(func f () (setq x 1) (setq g (eval '(lambda () x))) (setq x 2) (g)) (f)",
+		"(func f () (setq x 1) (setq g (eval '(lambda () (setq x 5)))) (g) x) (f)",
+	} {
+		want, err := flylang.New().EvalString(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		got, err := flylang.New(flylang.WithVM()).EvalString(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if ast.Print(got) != ast.Print(want) {
+			t.Errorf("%s: expected %s, got %s", src, ast.Print(want), ast.Print(got))
+		}
+	}
+}